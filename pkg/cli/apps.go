@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) runCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run -- <command> [args...]",
+		Short: "Run a command through the tunnel",
+		Long:  "Start a command in the darp cgroup so only its traffic goes through WARP while everything else stays on the physical link. Under sudo the command runs as the invoking user.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			username, _ := cmd.Flags().GetString("user")
+			return c.handleRun(args, username)
+		},
+	}
+
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringP("user", "u", "", "Run the command as this user (defaults to SUDO_USER)")
+	return cmd
+}
+
+func (c *CLI) appsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apps",
+		Short: "Manage per-application tunneling",
+		Long:  "Control which applications use the tunnel when network.routing.mode is apps.",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "apply",
+		Short: "Load marking rules and app routing",
+		Long:  "Create the darp cgroup, mark traffic of it and of the units in apps.units, and route marked traffic through the tunnel. Run again after starting a listed unit.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleAppsApply()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show per-application tunneling state",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleAppsStatus()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove",
+		Short: "Remove marking rules, app routing and the cgroup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleAppsRemove()
+		},
+	})
+
+	return cmd
+}
+
+func (c *CLI) appTunnel() *network.AppTunnel {
+	return network.NewAppTunnel(c.config.Network.Interface, c.config.Apps.Cgroup, c.config.Apps.Mark, c.config.Apps.Units)
+}
+
+func (c *CLI) appRouting() *network.PolicyRouting {
+	policy := c.policyRouting()
+	policy.AppMark = c.config.Apps.Mark
+	return policy
+}
+
+func (c *CLI) handleAppsApply() error {
+	if c.config.Network.Routing.Mode != "apps" {
+		return fmt.Errorf("network.routing.mode is %q; set it to apps for per-application tunneling", c.config.Network.Routing.Mode)
+	}
+
+	fmt.Println("🎯 Applying per-application tunneling...")
+
+	skipped, err := c.appTunnel().Apply()
+	if err != nil {
+		return err
+	}
+	for _, unit := range skipped {
+		fmt.Printf("  ⚠️  %s\n", unit)
+	}
+	fmt.Printf("  ✅ Marking traffic of cgroup %s and %d unit(s)\n", c.config.Apps.Cgroup, len(c.config.Apps.Units)-len(skipped))
+
+	if _, err := net.InterfaceByName(c.config.Network.Interface); err != nil {
+		fmt.Printf("  ⚠️  %s is not up; marked traffic is routed once the tunnel connects\n", c.config.Network.Interface)
+		return nil
+	}
+	if err := c.appRouting().Install([]string{"0.0.0.0/0", "::/0"}); err != nil {
+		return err
+	}
+	fmt.Printf("  ✅ Marked traffic routed through %s (table %d)\n", c.config.Network.Interface, c.config.Network.Routing.Table)
+	return nil
+}
+
+func (c *CLI) handleAppsStatus() error {
+	apps := c.appTunnel()
+
+	status := "❌ not loaded"
+	if apps.Active() {
+		status = "✅ loaded"
+	}
+	fmt.Printf("  Marking rules:  %s\n", status)
+
+	status = "❌ not installed"
+	if c.appRouting().Installed() {
+		status = "✅ installed"
+	}
+	fmt.Printf("  App routing:    %s\n", status)
+
+	pids, _ := apps.Processes()
+	fmt.Printf("  Processes:      %d in cgroup %s\n", len(pids), c.config.Apps.Cgroup)
+	for _, unit := range c.config.Apps.Units {
+		fmt.Printf("  Unit:           %s\n", unit)
+	}
+	return nil
+}
+
+func (c *CLI) handleAppsRemove() error {
+	fmt.Println("🧹 Removing per-application tunneling...")
+
+	if err := c.appRouting().Remove(); err != nil {
+		return err
+	}
+	if err := c.appTunnel().Remove(); err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Per-application tunneling removed")
+	return nil
+}
+
+func (c *CLI) handleRun(args []string, username string) error {
+	if c.config.Network.Routing.Mode != "apps" {
+		return fmt.Errorf("network.routing.mode is %q; set it to apps to tunnel single commands", c.config.Network.Routing.Mode)
+	}
+
+	apps := c.appTunnel()
+	if !apps.Active() {
+		if _, err := apps.Apply(); err != nil {
+			return err
+		}
+	}
+	// Refuse rather than silently sending the command's traffic over the
+	// physical link.
+	if !c.appRouting().Installed() {
+		return fmt.Errorf("app routing is not installed; connect first or run darp apps apply")
+	}
+
+	if err := apps.Join(os.Getpid()); err != nil {
+		return err
+	}
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	if username == "" {
+		username = os.Getenv("SUDO_USER")
+	}
+	if username != "" && os.Geteuid() == 0 {
+		credential, err := userCredential(username)
+		if err != nil {
+			return err
+		}
+		child.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	}
+
+	// The terminal delivers Ctrl-C to the child as well; let it decide.
+	signal.Ignore(os.Interrupt, syscall.SIGQUIT)
+
+	if err := child.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode(), Err: err}
+		}
+		return fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	return nil
+}
+
+func userCredential(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s: %w", username, err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if groups, err := u.GroupIds(); err == nil {
+		for _, group := range groups {
+			if id, err := strconv.Atoi(group); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+	return credential, nil
+}
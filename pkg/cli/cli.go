@@ -3,32 +3,58 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/user"
 	"strings"
 	"time"
 
+	"darp/pkg/config"
+	"darp/pkg/logging"
+	"darp/pkg/network"
+	"darp/pkg/warp"
+
 	"github.com/spf13/cobra"
 )
 
 type CLI struct {
-	rootCmd *cobra.Command
+	rootCmd    *cobra.Command
+	config     *config.Config
+	configPath string
+	version    string
+	build      string
 }
 
-func NewCLI() *CLI {
-	cli := &CLI{}
+// Commands carrying this annotation, and their subcommands, run with an
+// invalid configuration so it can still be inspected, diagnosed and fixed.
+const annotationLenientConfig = "darp/lenient-config"
+
+// NewCLI builds the command tree. The configuration is loaded once the
+// global flags are parsed, before any command runs.
+func NewCLI(version, build string) *CLI {
+	cli := &CLI{version: version, build: build}
 	cli.setupCommands()
 	return cli
 }
 
 func (c *CLI) setupCommands() {
 	c.rootCmd = &cobra.Command{
-		Use:   "darp",
-		Short: "DARP - Cloudflare WARP client for Arch Linux",
-		Long:  "A modular Cloudflare WARP client designed specifically for Arch Linux with advanced networking features.",
+		Use:          "darp",
+		Short:        "DARP - Cloudflare WARP client for Arch Linux",
+		Long:         "A modular Cloudflare WARP client designed specifically for Arch Linux with advanced networking features.",
+		Version:      c.version,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			configPath, _ := cmd.Flags().GetString("config")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+			return c.loadConfig(configPath, verbose, !lenientConfig(cmd))
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			c.showWelcome()
 		},
 	}
+	c.rootCmd.SetVersionTemplate(fmt.Sprintf("DARP v%s (build %s)\nCloudflare WARP client for Arch Linux\n", c.version, c.build))
+	c.rootCmd.PersistentFlags().String("config", "", "Path to configuration file")
+	c.rootCmd.PersistentFlags().Bool("verbose", false, "Enable verbose logging")
 
 	c.rootCmd.AddCommand(c.connectCmd())
 	c.rootCmd.AddCommand(c.disconnectCmd())
@@ -36,6 +62,107 @@ func (c *CLI) setupCommands() {
 	c.rootCmd.AddCommand(c.configCmd())
 	c.rootCmd.AddCommand(c.testCmd())
 	c.rootCmd.AddCommand(c.optimizeCmd())
+	c.rootCmd.AddCommand(c.networkCmd())
+	c.rootCmd.AddCommand(c.runCmd())
+	c.rootCmd.AddCommand(c.appsCmd())
+	c.rootCmd.AddCommand(c.netnsCmd())
+	c.rootCmd.AddCommand(c.daemonCmd())
+	c.rootCmd.AddCommand(c.dnsCmd())
+	c.rootCmd.AddCommand(c.doctorCmd())
+	c.rootCmd.AddCommand(c.supportBundleCmd())
+}
+
+func lenientConfig(cmd *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if cmd.Annotations[annotationLenientConfig] == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig loads the configuration and sets up logging. An invalid
+// configuration is fatal when validate is set and a warning otherwise.
+// Warnings go to stderr so they never mix with JSON output.
+func (c *CLI) loadConfig(configPath string, verbose, validate bool) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		if validate {
+			return fmt.Errorf("configuration validation failed: %w (fix it with darp config set, or run darp doctor)", err)
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  Invalid configuration: %v\n", err)
+	}
+
+	resolvedPath, err := config.ResolvePath(configPath)
+	if err != nil {
+		return fmt.Errorf("cannot determine configuration path: %w", err)
+	}
+	c.config = cfg
+	c.configPath = resolvedPath
+
+	level := cfg.Logging.Level
+	if verbose {
+		level = "debug"
+	}
+	if err := logging.Setup(level, cfg.Logging.Format, cfg.Logging.Output); err != nil {
+		if validate {
+			return fmt.Errorf("failed to set up logging: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  Logging settings ignored: %v\n", err)
+	}
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "⚠️  Warning: Some operations may require root privileges")
+		fmt.Fprintln(os.Stderr, "   Consider running with sudo for full functionality")
+	}
+
+	if err := warp.CheckWireGuardInstallation(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  WireGuard not found: %v\n", err)
+		fmt.Fprintln(os.Stderr, "   Some features may not work without WireGuard")
+		fmt.Fprintln(os.Stderr, "   Install with: sudo pacman -S wireguard-tools")
+	}
+
+	return nil
+}
+
+// warpManager builds the tunnel runtime from the configuration. Every
+// command that touches the tunnel goes through it, so each setting takes
+// effect the same way everywhere.
+func (c *CLI) warpManager() *warp.Manager {
+	netCfg := c.config.Network
+	client := warp.NewClient(c.config.Cloudflare.WarpEndpoint, netCfg.MTU, netCfg.DNS)
+	client.APIURL = c.config.Cloudflare.APIURL
+	client.RegistrationFile = c.config.Cloudflare.RegistrationFile
+	warpManager := warp.NewManager(client, netCfg.Interface)
+	warpManager.SetTimeout(time.Duration(netCfg.Timeout) * time.Second)
+	warpManager.SetIPv6(c.config.Network.IPv6, c.config.Cloudflare.WarpEndpointV6)
+	warpManager.SetListenPort(c.config.Network.ListenPort)
+	warpManager.SetFwMark(c.config.Network.Routing.FwMark)
+	warpManager.SetKeepalive(c.config.Network.PersistentKeepalive, c.config.Network.AutoKeepalive, c.config.Cloudflare.TraceURL)
+
+	switch c.config.Network.Routing.Mode {
+	case "netns":
+		warpManager.SetNamespace(c.namespace())
+	case "apps":
+		warpManager.SetPolicyRouting(c.appRouting())
+		warpManager.SetAppTunnel(c.appTunnel())
+	default:
+		warpManager.SetPolicyRouting(c.policyRouting())
+		if c.config.Network.IPv6Guard != "off" {
+			warpManager.SetIPv6Guard(network.NewIPv6Guard(c.config.Network.Interface, c.config.Network.IPv6Guard))
+		}
+	}
+	return warpManager
+}
+
+func (c *CLI) policyRouting() *network.PolicyRouting {
+	routing := c.config.Network.Routing
+	policy := network.NewPolicyRouting(c.config.Network.Interface, routing.Table, routing.FwMark, routing.Priority)
+	policy.SnapshotFile = routing.SnapshotFile
+	return policy
 }
 
 func (c *CLI) connectCmd() *cobra.Command {
@@ -80,6 +207,8 @@ func (c *CLI) configCmd() *cobra.Command {
 		Use:   "config",
 		Short: "Manage configuration",
 		Long:  "View and modify DARP configuration settings",
+		// An invalid value must stay fixable.
+		Annotations: map[string]string{annotationLenientConfig: "true"},
 	}
 
 	cmd.AddCommand(&cobra.Command{
@@ -101,55 +230,33 @@ func (c *CLI) configCmd() *cobra.Command {
 	return cmd
 }
 
-func (c *CLI) testCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "test",
-		Short: "Run network tests",
-		Long:  "Perform various network connectivity and performance tests",
+// captivePortal probes for a captive portal over the physical path and
+// returns the result when one was detected. A tunnel cannot get past a
+// portal, so nothing should connect while it is there.
+func (c *CLI) captivePortal(netManager *network.Manager) *network.PortalResult {
+	physical, err := netManager.PhysicalPath()
+	if err != nil {
+		return nil
 	}
-
-	cmd.AddCommand(&cobra.Command{
-		Use:   "connectivity",
-		Short: "Test basic connectivity",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return c.handleTestConnectivity()
-		},
-	})
-
-	cmd.AddCommand(&cobra.Command{
-		Use:   "latency",
-		Short: "Test latency to various endpoints",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return c.handleTestLatency()
-		},
-	})
-
-	cmd.AddCommand(&cobra.Command{
-		Use:   "dns",
-		Short: "Test DNS resolution",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return c.handleTestDNS()
-		},
-	})
-
-	return cmd
-}
-
-func (c *CLI) optimizeCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "optimize",
-		Short: "Optimize network settings",
-		Long:  "Apply network optimizations for better WARP performance",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return c.handleOptimize()
-		},
+	portal := c.config.CaptivePortal
+	result, err := physical.DetectCaptivePortal(portal.URL, portal.ExpectStatus, portal.ExpectBody)
+	if err != nil || !result.Detected {
+		return nil
 	}
+	return result
 }
 
 func (c *CLI) handleConnect() error {
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+	if portal := c.captivePortal(netManager); portal != nil {
+		return fmt.Errorf("captive portal detected: %s (log in, then connect again)", portal.PortalURL)
+	}
+
 	fmt.Println("🔗 Connecting to Cloudflare WARP...")
 
-	time.Sleep(2 * time.Second)
+	if err := c.warpManager().Connect(); err != nil {
+		return err
+	}
 
 	fmt.Println("✅ Successfully connected to Cloudflare WARP")
 	return nil
@@ -158,22 +265,16 @@ func (c *CLI) handleConnect() error {
 func (c *CLI) handleDisconnect() error {
 	fmt.Println("🔌 Disconnecting from Cloudflare WARP...")
 
-	time.Sleep(1 * time.Second)
+	if err := c.warpManager().Disconnect(); err != nil {
+		return err
+	}
 
 	fmt.Println("✅ Successfully disconnected from Cloudflare WARP")
 	return nil
 }
 
 func (c *CLI) handleStatus(format string) error {
-	status := map[string]interface{}{
-		"connected":      true,
-		"interface":      "warp0",
-		"ip_address":     "10.0.0.1",
-		"dns_servers":    []string{"1.1.1.1", "1.0.0.1"},
-		"uptime":         "2h 15m",
-		"bytes_sent":     "1.2 GB",
-		"bytes_received": "3.4 GB",
-	}
+	status := c.warpManager().GetStatus()
 
 	switch format {
 	case "json":
@@ -194,60 +295,74 @@ func (c *CLI) printStatusTable(status map[string]interface{}) {
 	fmt.Println("│              DARP Status                │")
 	fmt.Println("├─────────────────────────────────────────┤")
 
-	if connected, ok := status["connected"].(bool); ok {
-		statusText := "❌ Disconnected"
-		if connected {
-			statusText = "✅ Connected"
-		}
-		fmt.Printf("│ Status: %-30s │\n", statusText)
+	row := func(label, value string) {
+		fmt.Printf("│ %-15s %-23s │\n", label+":", value)
 	}
 
-	if iface, ok := status["interface"].(string); ok {
-		fmt.Printf("│ Interface: %-27s │\n", iface)
+	statusText := "❌ Disconnected"
+	if connected, _ := status["connected"].(bool); connected {
+		statusText = "✅ Connected"
 	}
+	row("Status", statusText)
 
-	if ip, ok := status["ip_address"].(string); ok {
-		fmt.Printf("│ IP Address: %-25s │\n", ip)
+	if iface, ok := status["interface"].(string); ok {
+		row("Interface", iface)
 	}
-
-	if dns, ok := status["dns_servers"].([]string); ok {
-		fmt.Printf("│ DNS Servers: %-23s │\n", strings.Join(dns, ", "))
+	if namespace, ok := status["namespace"].(string); ok {
+		row("Namespace", namespace)
 	}
-
-	if uptime, ok := status["uptime"].(string); ok {
-		fmt.Printf("│ Uptime: %-29s │\n", uptime)
+	if addresses, ok := status["addresses"].([]string); ok {
+		for i, addr := range addresses {
+			label := ""
+			if i == 0 {
+				label = "Addresses"
+			}
+			row(label, addr)
+		}
 	}
-
-	if sent, ok := status["bytes_sent"].(string); ok {
-		fmt.Printf("│ Data Sent: %-26s │\n", sent)
+	if endpoint, ok := status["endpoint"].(string); ok {
+		row("Endpoint", endpoint)
 	}
-
-	if received, ok := status["bytes_received"].(string); ok {
-		fmt.Printf("│ Data Received: %-21s │\n", received)
+	if dns, ok := status["dns"].([]string); ok {
+		row("DNS Servers", strings.Join(dns, ", "))
+	}
+	if handshake, ok := status["latest_handshake"].(string); ok {
+		row("Handshake", handshake)
+	}
+	if sent, ok := status["bytes_sent"].(int64); ok {
+		row("Data Sent", formatBytes(sent))
+	}
+	if received, ok := status["bytes_received"].(int64); ok {
+		row("Data Received", formatBytes(received))
+	}
+	if ipv6, ok := status["ipv6"].(string); ok {
+		row("IPv6", ipv6)
+	}
+	if guard, ok := status["ipv6_guard"].(string); ok {
+		if guard == "" {
+			guard = "inactive"
+		}
+		row("IPv6 Guard", guard)
 	}
 
 	fmt.Println("└─────────────────────────────────────────┘")
 }
 
-func (c *CLI) handleConfigShow() error {
-	config := map[string]interface{}{
-		"cloudflare": map[string]string{
-			"warp_endpoint": "engage.cloudflareclient.com:2408",
-		},
-		"network": map[string]interface{}{
-			"interface": "warp0",
-			"dns":       []string{"1.1.1.1", "1.0.0.1"},
-			"mtu":       1280,
-			"timeout":   30,
-		},
-		"logging": map[string]string{
-			"level":  "info",
-			"format": "json",
-			"output": "stdout",
-		},
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
 	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-	jsonData, err := json.MarshalIndent(config, "", "  ")
+func (c *CLI) handleConfigShow() error {
+	jsonData, err := json.MarshalIndent(c.config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -256,99 +371,67 @@ func (c *CLI) handleConfigShow() error {
 	return nil
 }
 
+// handleConfigSet sets a dotted key such as network.mtu. Values that parse
+// as JSON are used as such, anything else as a string. The result must
+// validate before it is saved, unless the configuration was invalid to begin
+// with, so several bad values can be fixed one at a time.
 func (c *CLI) handleConfigSet(args []string) error {
-	if len(args) < 2 {
+	if len(args) != 2 {
 		return fmt.Errorf("usage: darp config set <key> <value>")
 	}
 
 	key := args[0]
-	value := args[1]
-
-	fmt.Printf("Setting %s = %s\n", key, value)
-	fmt.Println("Configuration updated successfully")
-	return nil
-}
-
-func (c *CLI) handleTestConnectivity() error {
-	fmt.Println("🔍 Testing network connectivity...")
+	var value interface{} = args[1]
+	if err := json.Unmarshal([]byte(args[1]), &value); err != nil {
+		value = args[1]
+	}
 
-	tests := []struct {
-		name string
-		pass bool
-	}{
-		{"DNS Resolution", true},
-		{"Internet Connectivity", true},
-		{"Cloudflare WARP API", true},
-		{"WireGuard Interface", true},
+	data, err := json.Marshal(c.config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	for _, test := range tests {
-		status := "❌ FAIL"
-		if test.pass {
-			status = "✅ PASS"
+	node := tree
+	path := strings.Split(key, ".")
+	for _, part := range path[:len(path)-1] {
+		child, ok := node[part].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unknown configuration key %q", key)
 		}
-		fmt.Printf("  %s %s\n", status, test.name)
-		time.Sleep(500 * time.Millisecond)
+		node = child
 	}
-
-	fmt.Println("\n🎉 All connectivity tests passed!")
-	return nil
-}
-
-func (c *CLI) handleTestLatency() error {
-	fmt.Println("⏱️  Testing latency to various endpoints...")
-
-	endpoints := map[string]string{
-		"Cloudflare DNS (1.1.1.1)": "12ms",
-		"Cloudflare DNS (1.0.0.1)": "15ms",
-		"Google DNS (8.8.8.8)":     "25ms",
-		"Google DNS (8.8.4.4)":     "28ms",
+	if _, ok := node[path[len(path)-1]]; !ok {
+		return fmt.Errorf("unknown configuration key %q", key)
 	}
+	node[path[len(path)-1]] = value
 
-	for endpoint, latency := range endpoints {
-		fmt.Printf("  %s: %s\n", endpoint, latency)
-		time.Sleep(200 * time.Millisecond)
+	data, err = json.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
 	}
-
-	return nil
-}
-
-func (c *CLI) handleTestDNS() error {
-	fmt.Println("🌐 Testing DNS resolution...")
-
-	domains := []string{
-		"cloudflare.com",
-		"google.com",
-		"github.com",
-		"archlinux.org",
+	updated := &config.Config{}
+	if err := json.Unmarshal(data, updated); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
 	}
-
-	for _, domain := range domains {
-		fmt.Printf("  Resolving %s... ", domain)
-		time.Sleep(300 * time.Millisecond)
-		fmt.Println("✅ OK")
+	invalid := updated.Validate()
+	if invalid != nil && c.config.Validate() == nil {
+		return invalid
 	}
-
-	return nil
-}
-
-func (c *CLI) handleOptimize() error {
-	fmt.Println("⚡ Optimizing network settings...")
-
-	optimizations := []string{
-		"Setting TCP congestion control to BBR",
-		"Increasing network buffer sizes",
-		"Optimizing WireGuard MTU",
-		"Configuring DNS caching",
+	if err := updated.Save(c.configPath); err != nil {
+		return err
 	}
 
-	for _, opt := range optimizations {
-		fmt.Printf("  %s... ", opt)
-		time.Sleep(500 * time.Millisecond)
-		fmt.Println("✅ Done")
+	c.config = updated
+	fmt.Printf("Setting %s = %s\n", key, args[1])
+	if invalid != nil {
+		fmt.Printf("⚠️  Configuration saved but still invalid: %v\n", invalid)
+		return nil
 	}
-
-	fmt.Println("\n🎯 Network optimization completed!")
+	fmt.Println("Configuration updated successfully")
 	return nil
 }
 
@@ -360,7 +443,7 @@ func (c *CLI) showWelcome() {
 	username := c.getUsername()
 
 	fmt.Println("┌─────────────────────────────────────────┐")
-	fmt.Println("│              DARP v1.0.0                │")
+	fmt.Printf("│              DARP v%-20s │\n", c.version)
 	fmt.Println("│        Cloudflare WARP Client          │")
 	fmt.Println("├─────────────────────────────────────────┤")
 	fmt.Printf("│ Hello %-31s │\n", username+"!")
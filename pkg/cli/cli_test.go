@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"darp/pkg/config"
+	"darp/pkg/network"
+)
+
+func resetLogging() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+}
+
+// registrationServer answers WARP registrations with a fixed device.
+func registrationServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/reg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"test","token":"t","config":{"peers":[{"public_key":"cGVlcg=="}],` +
+			`"interface":{"addresses":{"v4":"172.16.0.2","v6":"2606:4700:110:8001::2"}}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testConfig writes a configuration with non-default values to a file.
+func testConfig(t *testing.T, apiURL string) (string, *config.Config) {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.Network.Interface = "wgtest7"
+	cfg.Network.MTU = 1380
+	cfg.Network.Timeout = 7
+	cfg.Network.DNS = []string{"9.9.9.9", "2620:fe::fe"}
+	cfg.Cloudflare.WarpEndpoint = "198.51.100.7:2409"
+	cfg.Cloudflare.APIURL = apiURL
+	cfg.Cloudflare.RegistrationFile = filepath.Join(dir, "registration.json")
+	cfg.Logging.Level = "warn"
+	cfg.Logging.Format = "text"
+	cfg.Logging.Output = filepath.Join(dir, "darp.log")
+
+	path := filepath.Join(dir, "config.json")
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path, cfg
+}
+
+func loadTestCLI(t *testing.T, path string) *CLI {
+	t.Helper()
+	t.Cleanup(resetLogging)
+	c := NewCLI("test", "test")
+	if err := c.loadConfig(path, false, true); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return c
+}
+
+func TestWarpManagerFromConfig(t *testing.T) {
+	path, want := testConfig(t, registrationServer(t).URL)
+	c := loadTestCLI(t, path)
+
+	m := c.warpManager()
+	if got := m.InterfaceName(); got != want.Network.Interface {
+		t.Errorf("interface = %q, want %q", got, want.Network.Interface)
+	}
+	if got := m.Timeout(); got != 7*time.Second {
+		t.Errorf("timeout = %s, want 7s", got)
+	}
+
+	client := m.Client()
+	if client.Endpoint != want.Cloudflare.WarpEndpoint {
+		t.Errorf("client endpoint = %q, want %q", client.Endpoint, want.Cloudflare.WarpEndpoint)
+	}
+	if client.MTU != want.Network.MTU {
+		t.Errorf("client MTU = %d, want %d", client.MTU, want.Network.MTU)
+	}
+	if !reflect.DeepEqual(client.DNS, want.Network.DNS) {
+		t.Errorf("client DNS = %v, want %v", client.DNS, want.Network.DNS)
+	}
+
+	wgConfig, err := client.GetWARPConfig()
+	if err != nil {
+		t.Fatalf("GetWARPConfig: %v", err)
+	}
+	if wgConfig.MTU != 1380 {
+		t.Errorf("WireGuard MTU = %d, want 1380", wgConfig.MTU)
+	}
+	if !reflect.DeepEqual(wgConfig.Interface.DNS, want.Network.DNS) {
+		t.Errorf("WireGuard DNS = %v, want %v", wgConfig.Interface.DNS, want.Network.DNS)
+	}
+	if len(wgConfig.Peers) != 1 || wgConfig.Peers[0].Endpoint != "198.51.100.7:2409" {
+		t.Errorf("WireGuard peers = %+v, want endpoint 198.51.100.7:2409", wgConfig.Peers)
+	}
+}
+
+func TestStatusFromConfig(t *testing.T) {
+	path, want := testConfig(t, "http://127.0.0.1:1")
+	c := loadTestCLI(t, path)
+
+	status := c.warpManager().GetStatus()
+	for key, value := range map[string]interface{}{
+		"interface": want.Network.Interface,
+		"mtu":       want.Network.MTU,
+		"dns":       want.Network.DNS,
+		"endpoint":  want.Cloudflare.WarpEndpoint,
+	} {
+		if !reflect.DeepEqual(status[key], value) {
+			t.Errorf("status[%q] = %v, want %v", key, status[key], value)
+		}
+	}
+}
+
+func TestLoadConfigSetsUpLogging(t *testing.T) {
+	path, cfg := testConfig(t, "http://127.0.0.1:1")
+	loadTestCLI(t, path)
+
+	log.Print("Connecting")
+	log.Print("Warning: handshake slow")
+
+	data, err := os.ReadFile(cfg.Logging.Output)
+	if err != nil {
+		t.Fatalf("log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log file has %d records, want only the warning:\n%s", len(lines), data)
+	}
+	if !strings.Contains(lines[0], "level=WARN") || !strings.Contains(lines[0], `msg="handshake slow"`) {
+		t.Errorf("record %q is not a text warning", lines[0])
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	path, cfg := testConfig(t, "http://127.0.0.1:1")
+	cfg.Network.MTU = 100
+	cfg.Logging.Output = "stderr"
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(resetLogging)
+
+	c := NewCLI("test", "test")
+	if err := c.loadConfig(path, false, true); err == nil {
+		t.Error("invalid configuration loaded for a command that acts on it")
+	}
+	if err := c.loadConfig(path, false, false); err != nil {
+		t.Errorf("invalid configuration rejected for a lenient command: %v", err)
+	}
+
+	for _, args := range [][]string{{"config", "show"}, {"config", "set"}, {"doctor"}, {"support-bundle"}} {
+		cmd, _, err := c.rootCmd.Find(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !lenientConfig(cmd) {
+			t.Errorf("darp %s validates the configuration", strings.Join(args, " "))
+		}
+	}
+	for _, args := range [][]string{{"connect"}, {"status"}, {"daemon"}} {
+		cmd, _, err := c.rootCmd.Find(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lenientConfig(cmd) {
+			t.Errorf("darp %s runs with an invalid configuration", strings.Join(args, " "))
+		}
+	}
+}
+
+func TestConfigSetFixesInvalidConfig(t *testing.T) {
+	path, cfg := testConfig(t, "http://127.0.0.1:1")
+	cfg.Network.MTU = 100
+	cfg.Network.Timeout = 0
+	cfg.Logging.Output = "stderr"
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(resetLogging)
+
+	c := NewCLI("test", "test")
+	if err := c.loadConfig(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"network.mtu", "1280"}, {"network.timeout", "30"}} {
+		if err := c.handleConfigSet(args); err != nil {
+			t.Fatalf("config set %s: %v", args[0], err)
+		}
+	}
+
+	saved, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.Validate(); err != nil {
+		t.Errorf("configuration still invalid: %v", err)
+	}
+
+	// A valid configuration must not be broken by a bad value.
+	if err := c.handleConfigSet([]string{"network.mtu", "100"}); err == nil {
+		t.Error("config set saved an invalid value")
+	}
+	if err := c.handleConfigSet([]string{"network.nonexistent", "1"}); err == nil {
+		t.Error("config set accepted an unknown key")
+	}
+
+	var raw map[string]interface{}
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &raw)
+	if mtu := raw["network"].(map[string]interface{})["mtu"]; mtu != float64(1280) {
+		t.Errorf("saved mtu = %v, want 1280", mtu)
+	}
+}
+
+// The defaults are kept as literals in both packages so config does not
+// import network; this keeps them from drifting apart.
+func TestDefaultTestsMatchNetwork(t *testing.T) {
+	c := &CLI{config: config.DefaultConfig()}
+
+	targets, err := c.testTargets(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, network.DefaultTargets()) {
+		t.Errorf("default targets = %+v, want %+v", targets, network.DefaultTargets())
+	}
+	if !reflect.DeepEqual(c.config.Tests.DNSDomains, network.DefaultTestDomains) {
+		t.Errorf("default dns domains = %v, want %v", c.config.Tests.DNSDomains, network.DefaultTestDomains)
+	}
+}
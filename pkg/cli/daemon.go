@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) daemonCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "daemon",
+		Short: "Keep the tunnel working across network changes",
+		Long:  "Watch link, address and route changes and refresh the tunnel when the default route changes or the system resumes from suspend. With auto_connect enabled, connect on untrusted networks and disconnect on trusted ones. Runs in the foreground until interrupted.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleDaemon()
+		},
+	}
+}
+
+func (c *CLI) trustedRules() []network.TrustedRule {
+	var rules []network.TrustedRule
+	for _, trusted := range c.config.AutoConnect.TrustedNetworks {
+		rules = append(rules, network.TrustedRule{
+			Name:         trusted.Name,
+			GatewayMAC:   trusted.GatewayMAC,
+			Subnet:       trusted.Subnet,
+			Interface:    trusted.Interface,
+			SearchDomain: trusted.SearchDomain,
+		})
+	}
+	return rules
+}
+
+func (c *CLI) handleDaemon() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+	warpManager := c.warpManager()
+	rules := c.trustedRules()
+	portal := c.config.CaptivePortal
+
+	// The monitor and the portal recheck both act on the tunnel; mu
+	// serializes them.
+	var mu sync.Mutex
+	portalPaused := false
+
+	// autoConnect brings the tunnel up or down for the current network and
+	// reports whether it changed anything. Behind a captive portal it pauses
+	// until the portal is cleared. Callers hold mu.
+	autoConnect := func() bool {
+		if !c.config.AutoConnect.Enabled {
+			return false
+		}
+
+		id, err := netManager.CurrentNetwork()
+		if err != nil {
+			log.Printf("Cannot identify network: %v", err)
+			return false
+		}
+
+		rule, trusted := network.MatchTrusted(rules, id)
+		up := warpManager.TunnelUp()
+		switch {
+		case trusted:
+			portalPaused = false
+			if !up {
+				return false
+			}
+			log.Printf("Trusted network %q on %s, disconnecting", rule.Name, id.Interface)
+			if err := warpManager.Disconnect(); err != nil {
+				log.Printf("Warning: failed to disconnect: %v", err)
+			}
+			return true
+		case !trusted && !up:
+			if result := c.captivePortal(netManager); result != nil {
+				if !portalPaused {
+					log.Printf("Captive portal detected (%s): log in at %s; auto-connect paused", result.Detail, result.PortalURL)
+				}
+				portalPaused = true
+				return false
+			}
+			if portalPaused {
+				log.Printf("Captive portal cleared")
+			}
+			portalPaused = false
+			log.Printf("Untrusted network on %s (gateway %s %s), connecting", id.Interface, id.Gateway, id.GatewayMAC)
+			if err := warpManager.Connect(); err != nil {
+				log.Printf("Warning: failed to connect: %v", err)
+			}
+			return true
+		}
+		return false
+	}
+
+	debounce := time.Duration(c.config.Monitor.DebounceMS) * time.Millisecond
+	monitor := network.NewMonitor(c.config.Network.Interface, debounce, func(change network.NetworkChange) {
+		log.Printf("Network change (%s): %s -> %s", change.Reason, change.Previous, change.Current)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if autoConnect() || !warpManager.TunnelUp() {
+			return
+		}
+		if err := warpManager.Refresh(warpManager.Endpoint(c.config.Cloudflare.WarpEndpoint)); err != nil {
+			log.Printf("Warning: failed to refresh tunnel: %v", err)
+		}
+	})
+
+	mu.Lock()
+	autoConnect()
+	mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(portal.RecheckSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				if portalPaused {
+					autoConnect()
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	log.Printf("Watching network changes (debounce %s)", debounce)
+	return monitor.Run(ctx)
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"darp/pkg/dns"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) dnsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Manage the local DNS stub",
+		Long:  "Run the local DNS stub resolver with domain blocklists and inspect its statistics",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "serve",
+		Short: "Run the DNS stub in the foreground",
+		Long:  "Forward queries to the configured DNS servers, answering blocklisted domains locally. Send SIGHUP to reload blocklists.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleDNSServe()
+		},
+	})
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show DNS stub statistics and blocklist hits",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			top, _ := cmd.Flags().GetInt("top")
+			return c.handleDNSStats(format, top)
+		},
+	}
+	statsCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	statsCmd.Flags().IntP("top", "n", 10, "Number of most blocked domains to show")
+	cmd.AddCommand(statsCmd)
+
+	whoamiCmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Serve a local leak test zone",
+		Long:  "Answer every name under the zone with the address of the asking resolver, as a local stand-in for the public leak test zone",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, _ := cmd.Flags().GetString("listen")
+			zone, _ := cmd.Flags().GetString("zone")
+			return c.handleDNSWhoami(listen, zone)
+		},
+	}
+	whoamiCmd.Flags().String("listen", "127.0.0.1:5300", "UDP address to listen on")
+	whoamiCmd.Flags().String("zone", "", "Zone to serve (defaults to leak_test.zone)")
+	cmd.AddCommand(whoamiCmd)
+
+	return cmd
+}
+
+func (c *CLI) handleDNSServe() error {
+	stub := c.config.DNSStub
+
+	blocklist := dns.NewBlocklist(stub.Blocklists)
+	if err := blocklist.Load(); err != nil {
+		return err
+	}
+
+	timeout := time.Duration(c.config.Network.Timeout) * time.Second
+	server := dns.NewServer(stub.Listen, c.config.Network.DNS, blocklist, stub.BlockMode, timeout)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					if err := server.Reload(); err != nil {
+						fmt.Printf("⚠️  Blocklist reload failed: %v\n", err)
+					}
+					continue
+				}
+				server.Close()
+				return
+			case <-ticker.C:
+				if err := server.WriteStats(stub.StatsFile); err != nil {
+					fmt.Printf("⚠️  %v\n", err)
+				}
+			}
+		}
+	}()
+
+	err := server.ListenAndServe()
+
+	if werr := server.WriteStats(stub.StatsFile); werr != nil {
+		fmt.Printf("⚠️  %v\n", werr)
+	}
+	return err
+}
+
+func (c *CLI) handleDNSWhoami(listen, zone string) error {
+	if zone == "" {
+		zone = c.config.LeakTest.Zone
+	}
+
+	server := dns.NewWhoamiServer(listen, zone)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	return server.ListenAndServe()
+}
+
+func (c *CLI) handleDNSStats(format string, top int) error {
+	stats, err := dns.LoadStats(c.config.DNSStub.StatsFile)
+	if err != nil {
+		return fmt.Errorf("%w (is `darp dns serve` running?)", err)
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal stats: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	fmt.Println("🛡️  DNS stub statistics")
+	fmt.Printf("  Queries:          %d\n", stats.Queries)
+	fmt.Printf("  Blocked:          %d\n", stats.Blocked)
+	fmt.Printf("  Forwarded:        %d\n", stats.Forwarded)
+	fmt.Printf("  Failed:           %d\n", stats.Failed)
+	fmt.Printf("  Blocklist size:   %d\n", stats.BlocklistEntries)
+	fmt.Printf("  Updated:          %s\n", stats.UpdatedAt.Format(time.RFC3339))
+
+	hits := stats.TopHits(top)
+	if len(hits) == 0 {
+		return nil
+	}
+
+	fmt.Println("\n  Top blocked domains:")
+	for _, hit := range hits {
+		fmt.Printf("    %-40s %d\n", hit.Domain, hit.Hits)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"darp/pkg/doctor"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) doctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose common problems",
+		Long:  "Run a series of checks on the system, configuration and tunnel and print a fix for every problem found. Exits non-zero when an error is found.",
+		// Diagnosing an invalid configuration is part of the job.
+		Annotations: map[string]string{annotationLenientConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleDoctor(format)
+		},
+	}
+
+	cmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	return cmd
+}
+
+func (c *CLI) handleDoctor(format string) error {
+	if format != "json" {
+		fmt.Println("🩺 Running diagnostics...")
+	}
+
+	report := doctor.New(c.config).Run()
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal doctor report: %w", err)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		printDoctorReport(report)
+	}
+
+	if report.Errors > 0 {
+		return &ExitError{Code: 1, Err: fmt.Errorf("doctor found %d error(s)", report.Errors)}
+	}
+	return nil
+}
+
+func printDoctorReport(report *doctor.Report) {
+	icons := map[doctor.Severity]string{
+		doctor.SeverityOK:      "✅",
+		doctor.SeverityInfo:    "ℹ️ ",
+		doctor.SeverityWarning: "⚠️ ",
+		doctor.SeverityError:   "❌",
+	}
+
+	for _, finding := range report.Findings {
+		fmt.Printf("  %s %-18s %s\n", icons[finding.Severity], finding.Check, finding.Summary)
+		if finding.Explanation != "" {
+			fmt.Printf("     %s\n", finding.Explanation)
+		}
+		if finding.Fix != "" {
+			fmt.Printf("     Fix: %s\n", finding.Fix)
+		}
+	}
+
+	fmt.Printf("\n%d error(s), %d warning(s) in %s\n", report.Errors, report.Warnings, report.Duration)
+}
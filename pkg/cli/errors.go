@@ -0,0 +1,16 @@
+package cli
+
+// ExitError carries a specific process exit status out of a command, for
+// commands meant to be used as checks in scripts and CI.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
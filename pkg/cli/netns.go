@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) netnsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "netns",
+		Short: "Use the isolated tunnel namespace",
+		Long:  "When network.routing.mode is netns the tunnel lives in its own network namespace, and only programs started inside it can reach the network.",
+	}
+
+	execCmd := &cobra.Command{
+		Use:   "exec -- <command> [args...]",
+		Short: "Run a command inside the tunnel namespace",
+		Long:  "Run a command in the namespace whose only uplink is the WARP interface, with the namespace's own resolv.conf. Under sudo the command runs as the invoking user.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			username, _ := cmd.Flags().GetString("user")
+			return c.handleNetnsExec(args, username)
+		},
+	}
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringP("user", "u", "", "Run the command as this user (defaults to SUDO_USER)")
+	cmd.AddCommand(execCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the tunnel namespace",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleNetnsStatus()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete",
+		Short: "Delete the tunnel namespace and the interface inside it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleNetnsDelete()
+		},
+	})
+
+	return cmd
+}
+
+func (c *CLI) namespace() *network.Namespace {
+	return network.NewNamespace(c.config.Netns.Name, c.config.Network.Interface, c.config.Network.DNS)
+}
+
+func (c *CLI) handleNetnsExec(args []string, username string) error {
+	ns := c.namespace()
+	if !ns.Exists() {
+		return fmt.Errorf("namespace %s does not exist; connect with network.routing.mode set to netns first", ns.Name)
+	}
+
+	if username == "" {
+		username = os.Getenv("SUDO_USER")
+	}
+
+	child := ns.Command(username, args...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	signal.Ignore(os.Interrupt, syscall.SIGQUIT)
+
+	if err := child.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode(), Err: err}
+		}
+		return fmt.Errorf("failed to run %s in %s: %w", args[0], ns.Name, err)
+	}
+	return nil
+}
+
+func (c *CLI) handleNetnsStatus() error {
+	ns := c.namespace()
+	if !ns.Exists() {
+		fmt.Printf("  Namespace %s: ❌ not created\n", ns.Name)
+		return nil
+	}
+
+	fmt.Printf("  Namespace %s: ✅ present\n", ns.Name)
+
+	output, err := ns.Command("", "ip", "-brief", "addr", "show", "dev", ns.Interface).Output()
+	if err != nil {
+		fmt.Printf("  Interface %s: ❌ missing\n", ns.Interface)
+	} else {
+		fmt.Printf("  Interface:    %s", output)
+	}
+
+	pids, _ := ns.Processes()
+	fmt.Printf("  Processes:    %d\n", len(pids))
+	return nil
+}
+
+func (c *CLI) handleNetnsDelete() error {
+	fmt.Printf("🧹 Deleting namespace %s...\n", c.config.Netns.Name)
+	if err := c.namespace().Delete(); err != nil {
+		return err
+	}
+	fmt.Println("✅ Namespace deleted")
+	return nil
+}
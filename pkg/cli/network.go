@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) networkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "network",
+		Short: "Inspect the host network configuration",
+		Long:  "Show the routing tables and policy rules that decide where traffic goes.",
+	}
+
+	routesCmd := &cobra.Command{
+		Use:   "routes",
+		Short: "Show routes and policy rules",
+		Long:  "List IPv4 and IPv6 routes of every routing table and the ip rules that select them, read over netlink.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			table, _ := cmd.Flags().GetString("table")
+			family, _ := cmd.Flags().GetString("family")
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleNetworkRoutes(table, family, format)
+		},
+	}
+	routesCmd.Flags().String("table", "", "Only show this table (name or number; local is hidden unless named)")
+	routesCmd.Flags().String("family", "", "Only show this address family (inet, inet6)")
+	routesCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(routesCmd)
+
+	currentCmd := &cobra.Command{
+		Use:   "current",
+		Short: "Identify the current network",
+		Long:  "Show the interface, gateway, gateway MAC, subnets and search domains of the physical network and which trusted network rule matches it.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleNetworkCurrent(format)
+		},
+	}
+	currentCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(currentCmd)
+
+	return cmd
+}
+
+func (c *CLI) handleNetworkCurrent(format string) error {
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+
+	id, err := netManager.CurrentNetwork()
+	if err != nil {
+		return err
+	}
+	rule, trusted := network.MatchTrusted(c.trustedRules(), id)
+
+	if format == "json" {
+		result := map[string]interface{}{"network": id, "trusted": trusted}
+		if trusted {
+			result["rule"] = rule.Name
+		}
+		jsonData, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal network identity: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	fmt.Printf("  Interface:      %s\n", id.Interface)
+	fmt.Printf("  Gateway:        %s\n", id.Gateway)
+	fmt.Printf("  Gateway MAC:    %s\n", dashIfEmpty(id.GatewayMAC))
+	fmt.Printf("  Subnets:        %s\n", strings.Join(id.Subnets, ", "))
+	fmt.Printf("  Search domains: %s\n", dashIfEmpty(strings.Join(id.SearchDomains, ", ")))
+	if trusted {
+		fmt.Printf("\n🏠 Trusted network %q\n", rule.Name)
+	} else {
+		fmt.Println("\n🌐 Untrusted network")
+	}
+	return nil
+}
+
+func (c *CLI) handleNetworkRoutes(table, family, format string) error {
+	routes, err := network.ListRoutes()
+	if err != nil {
+		return err
+	}
+	rules, err := network.ListRules()
+	if err != nil {
+		return err
+	}
+
+	var shownRoutes []network.Route
+	for _, route := range routes {
+		if family != "" && route.Family != family {
+			continue
+		}
+		if table == "" && route.Table == network.RouteTableLocal {
+			continue
+		}
+		if table != "" && table != network.TableName(route.Table) && table != strconv.Itoa(route.Table) {
+			continue
+		}
+		shownRoutes = append(shownRoutes, route)
+	}
+
+	var shownRules []network.Rule
+	for _, rule := range rules {
+		if family == "" || rule.Family == family {
+			shownRules = append(shownRules, rule)
+		}
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(map[string]interface{}{
+			"routes": shownRoutes,
+			"rules":  shownRules,
+		}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal routes: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	fmt.Println("🧭 Policy rules:")
+	for _, rule := range shownRules {
+		fmt.Printf("  %-6s %s\n", rule.Family, rule)
+	}
+
+	current := -1
+	for _, route := range shownRoutes {
+		if route.Table != current {
+			current = route.Table
+			fmt.Printf("\n🗺️  Table %s:\n", network.TableName(current))
+			fmt.Printf("  %-6s %-28s %-24s %-12s %-8s %-8s %-7s %s\n", "FAMILY", "DESTINATION", "GATEWAY", "DEVICE", "PROTO", "SCOPE", "METRIC", "SOURCE")
+		}
+		dst := route.Dst
+		if route.Type != "unicast" {
+			dst = route.Type + " " + dst
+		}
+		fmt.Printf("  %-6s %-28s %-24s %-12s %-8s %-8s %-7d %s\n", route.Family, dst, dashIfEmpty(route.Gateway), dashIfEmpty(route.Dev), route.Proto, route.Scope, route.Metric, route.Src)
+	}
+	return nil
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"darp/pkg/config"
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) optimizeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "optimize",
+		Short: "Optimize network settings",
+		Long:  "Apply an optimization profile (sysctl tunables, tunnel qdisc and MTU) for better WARP performance. Previous values are saved so --revert can restore them.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			profile, _ := cmd.Flags().GetString("profile")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			revert, _ := cmd.Flags().GetBool("revert")
+			persist, _ := cmd.Flags().GetBool("persist")
+			format, _ := cmd.Flags().GetString("format")
+			if revert {
+				return c.handleOptimizeRevert(format)
+			}
+			return c.handleOptimize(profile, dryRun, persist, format)
+		},
+	}
+
+	cmd.Flags().StringP("profile", "p", "", "Optimization profile (defaults to optimize.default_profile)")
+	cmd.Flags().Bool("dry-run", false, "Show what would change without applying it")
+	cmd.Flags().Bool("revert", false, "Restore the values saved before the last optimization")
+	cmd.Flags().Bool("persist", false, "Also write the tunables to optimize.persist_file so they survive reboots")
+	cmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	return cmd
+}
+
+func (c *CLI) handleOptimize(profileName string, dryRun, persist bool, format string) error {
+	if profileName == "" {
+		profileName = c.config.Optimize.DefaultProfile
+	}
+	profile, ok := c.config.Optimize.Profiles[profileName]
+	if !ok {
+		return fmt.Errorf("unknown optimization profile %q (available: %s)", profileName, strings.Join(c.profileNames(), ", "))
+	}
+
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+	tunables := c.profileTunables(netManager, profile)
+
+	if dryRun {
+		if format != "json" {
+			fmt.Printf("🔎 Changes for profile %s (dry run):\n", profileName)
+		}
+		results := netManager.PlanOptimizations(tunables)
+		if err := printTunableResults(results, format); err != nil {
+			return err
+		}
+		if format != "json" {
+			fmt.Printf("\n%d change(s) pending. Apply with: darp optimize --profile %s\n", countTunables(results, network.TunablePending), profileName)
+		}
+		return nil
+	}
+
+	if format != "json" {
+		fmt.Printf("⚡ Optimizing network settings with profile %s...\n", profileName)
+	}
+
+	results, err := netManager.OptimizeNetwork(tunables, c.config.Optimize.SnapshotFile)
+	if err != nil && results == nil {
+		return err
+	}
+
+	if err := printTunableResults(results, format); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if persist {
+		if err := network.PersistOptimizations(results, c.config.Optimize.PersistFile); err != nil {
+			return err
+		}
+		if format != "json" {
+			fmt.Printf("\n💾 Persisted to %s\n", c.config.Optimize.PersistFile)
+		}
+	}
+
+	if failed := countTunables(results, network.TunableFailed); failed > 0 {
+		return &ExitError{Code: 1, Err: fmt.Errorf("%d tunable(s) could not be applied", failed)}
+	}
+
+	if format != "json" {
+		fmt.Println("\n🎯 Network optimization completed! Undo with: darp optimize --revert")
+	}
+	return nil
+}
+
+// profileTunables turns a profile into tunables in a stable order: sysctl
+// keys sorted by name, then the tunnel qdisc and MTU.
+func (c *CLI) profileTunables(netManager *network.Manager, profile config.OptimizeProfile) []network.Tunable {
+	keys := make([]string, 0, len(profile.Sysctl))
+	for key := range profile.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tunables []network.Tunable
+	for _, key := range keys {
+		tunables = append(tunables, network.SysctlTunable(key, profile.Sysctl[key]))
+	}
+
+	iface := c.config.Network.Interface
+	if profile.Qdisc != "" {
+		tunables = append(tunables, network.QdiscTunable(iface, profile.Qdisc))
+	}
+
+	switch profile.MTU {
+	case "fixed":
+		tunables = append(tunables, network.MTUTunable(iface, c.config.Network.MTU))
+	case "discover":
+		// Probes through the tunnel would measure the tunnel MTU itself.
+		tunables = append(tunables, network.DiscoveredMTUTunable(iface, func() (int, error) {
+			physical, err := netManager.PhysicalPath()
+			if err != nil {
+				return 0, err
+			}
+			result, err := physical.DiscoverPathMTU(c.config.Cloudflare.WarpEndpoint, 1500)
+			if err != nil {
+				return 0, err
+			}
+			return result.TunnelMTU, nil
+		}))
+	}
+
+	return tunables
+}
+
+func (c *CLI) profileNames() []string {
+	names := make([]string, 0, len(c.config.Optimize.Profiles))
+	for name := range c.config.Optimize.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *CLI) handleOptimizeRevert(format string) error {
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+
+	if format != "json" {
+		fmt.Println("↩️  Reverting network optimizations...")
+	}
+
+	results, err := netManager.RevertOptimizations(c.config.Optimize.SnapshotFile, c.config.Optimize.PersistFile)
+	if perr := printTunableResults(results, format); perr != nil {
+		return perr
+	}
+	if err != nil {
+		return err
+	}
+
+	if format != "json" {
+		fmt.Println("\n✅ Previous network settings restored")
+	}
+	return nil
+}
+
+func printTunableResults(results []network.TunableResult, format string) error {
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal optimization results: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	icons := map[network.TunableStatus]string{
+		network.TunableApplied:   "✅",
+		network.TunablePending:   "📝",
+		network.TunableRestored:  "✅",
+		network.TunableUnchanged: "➖",
+		network.TunableSkipped:   "⏭️ ",
+		network.TunableFailed:    "❌",
+	}
+	for _, result := range results {
+		change := result.Value
+		if result.Previous != "" && result.Previous != result.Value {
+			change = result.Previous + " → " + result.Value
+		}
+		key := result.Key
+		if result.Name == result.Key {
+			key = ""
+		}
+		fmt.Printf("  %s %-32s %-34s %s\n", icons[result.Status], result.Name, key, change)
+		if result.Detail != "" {
+			fmt.Printf("     %s\n", result.Detail)
+		}
+	}
+	return nil
+}
+
+func countTunables(results []network.TunableResult, status network.TunableStatus) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
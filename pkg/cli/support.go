@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"darp/pkg/support"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) supportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect diagnostics for a bug report",
+		Long:  "Write a tarball with the redacted configuration, state, logs, network and firewall state, WireGuard statistics and doctor results",
+		// Bug reports often start with a broken configuration.
+		Annotations: map[string]string{annotationLenientConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, _ := cmd.Flags().GetString("output")
+			return c.handleSupportBundle(output)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "Bundle path (default darp-support-<timestamp>.tar.gz)")
+	return cmd
+}
+
+func (c *CLI) handleSupportBundle(output string) error {
+	if output == "" {
+		output = fmt.Sprintf("darp-support-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	fmt.Println("📦 Collecting support bundle...")
+
+	warnings, err := support.NewBundle(c.config, c.version).Write(output)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		fmt.Printf("  ⚠️  %s\n", warning)
+	}
+
+	fmt.Printf("\n✅ Support bundle written to %s\n", output)
+	fmt.Println("   Keys and secrets are redacted; review the contents before sharing.")
+	return nil
+}
@@ -0,0 +1,909 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"darp/pkg/network"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *CLI) testCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Run network tests",
+		Long:  "Perform various network connectivity and performance tests",
+	}
+
+	cmd.PersistentFlags().String("bind-interface", "", "Bind test sockets to this interface")
+	cmd.PersistentFlags().String("bind-source", "", "Send test traffic from this source address")
+	cmd.PersistentFlags().StringSliceP("target", "t", nil, "Targets to test: configured target names, hosts, host:port or URLs")
+	cmd.PersistentFlags().BoolP("ipv4", "4", false, "Test over IPv4 only")
+	cmd.PersistentFlags().BoolP("ipv6", "6", false, "Test over IPv6 only")
+
+	connectivityCmd := &cobra.Command{
+		Use:   "connectivity",
+		Short: "Test basic connectivity",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if compare, _ := cmd.Flags().GetBool("compare"); compare {
+				return c.handleCompareConnectivity(cmd)
+			}
+			return c.handleTestConnectivity(cmd)
+		},
+	}
+	addCompareFlags(connectivityCmd)
+	cmd.AddCommand(connectivityCmd)
+
+	latencyCmd := &cobra.Command{
+		Use:   "latency",
+		Short: "Test latency to various endpoints",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if compare, _ := cmd.Flags().GetBool("compare"); compare {
+				return c.handleCompareLatency(cmd)
+			}
+			return c.handleTestLatency(cmd)
+		},
+	}
+	addCompareFlags(latencyCmd)
+	defaults := network.DefaultProbeOptions()
+	latencyCmd.Flags().StringP("protocol", "p", "", "Probe protocol for every target (icmp, tcp)")
+	latencyCmd.Flags().IntP("count", "c", defaults.Count, "Probes per target")
+	latencyCmd.Flags().Duration("interval", defaults.Interval, "Delay between probes")
+	latencyCmd.Flags().Duration("timeout", defaults.Timeout, "Timeout per probe")
+	latencyCmd.Flags().Int("port", defaults.Port, "Port for tcp probes")
+	latencyCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(latencyCmd)
+
+	tunnelCmd := &cobra.Command{
+		Use:   "tunnel",
+		Short: "Verify traffic egresses via WARP",
+		Long:  "Fetch the trace endpoint and check whether it saw the request arrive through WARP",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			traceURL, _ := cmd.Flags().GetString("url")
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleTestTunnel(cmd, traceURL, format)
+		},
+	}
+	tunnelCmd.Flags().String("url", "", "Trace URL (defaults to cloudflare.trace_url)")
+	tunnelCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(tunnelCmd)
+
+	mtuCmd := &cobra.Command{
+		Use:   "mtu",
+		Short: "Discover the path MTU to the WARP endpoint",
+		Long:  "Probe the WARP endpoint with don't-fragment ICMP echoes to find the path MTU and compute the best tunnel MTU",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleTestMTU(cmd)
+		},
+	}
+	mtuCmd.Flags().String("endpoint", "", "Endpoint to probe (defaults to cloudflare.warp_endpoint)")
+	mtuCmd.Flags().Int("max", 1500, "Largest path MTU to probe")
+	mtuCmd.Flags().Bool("write", false, "Save the tunnel MTU to network.mtu in the configuration")
+	mtuCmd.Flags().Bool("apply", false, "Set the tunnel MTU on the live WireGuard interface")
+	mtuCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(mtuCmd)
+
+	speedCmd := &cobra.Command{
+		Use:   "speed",
+		Short: "Measure throughput and loaded latency",
+		Long:  "Measure download and upload throughput and latency under load against an HTTP speed test endpoint",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.handleTestSpeed(cmd)
+		},
+	}
+	addCompareFlags(speedCmd)
+	speedCmd.Flags().String("url", "", "Speed test endpoint (defaults to speed_test.url)")
+	speedCmd.Flags().Int64("download-bytes", 0, "Bytes per download round (defaults to speed_test.download_bytes)")
+	speedCmd.Flags().Int64("upload-bytes", 0, "Bytes per upload round (defaults to speed_test.upload_bytes)")
+	speedCmd.Flags().Int("rounds", 0, "Download and upload rounds (defaults to speed_test.rounds)")
+	speedCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(speedCmd)
+
+	speedServerCmd := &cobra.Command{
+		Use:   "speed-server",
+		Short: "Serve a local speed test endpoint",
+		Long:  "Serve the /__down and /__up endpoints used by darp test speed, for offline testing",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, _ := cmd.Flags().GetString("listen")
+			fmt.Printf("🚀 Speed test server listening on http://%s\n", listen)
+			return http.ListenAndServe(listen, network.SpeedTestHandler())
+		},
+	}
+	speedServerCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.AddCommand(speedServerCmd)
+
+	dnsCmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Test DNS resolution",
+		Long:  "Query each configured DNS server directly for A and AAAA records",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			domains, _ := cmd.Flags().GetStringSlice("domain")
+			compare, _ := cmd.Flags().GetBool("compare-system")
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleTestDNS(cmd, domains, compare, format)
+		},
+	}
+	dnsCmd.Flags().StringSliceP("domain", "d", nil, "Domains to resolve (defaults to tests.dns_domains)")
+	dnsCmd.Flags().Bool("compare-system", false, "Compare answers with the system resolver to detect leaks")
+	dnsCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(dnsCmd)
+
+	leakCmd := &cobra.Command{
+		Use:   "leak",
+		Short: "Detect DNS leaks outside the tunnel",
+		Long:  "Resolve unique names under the leak test zone and report which resolvers actually answered. Exits 1 when a leak is found and 2 when the test is inconclusive.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			zone, _ := cmd.Flags().GetString("zone")
+			probes, _ := cmd.Flags().GetInt("probes")
+			resolver, _ := cmd.Flags().GetString("resolver")
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleTestLeak(cmd, zone, probes, resolver, format)
+		},
+	}
+	leakCmd.Flags().String("zone", "", "Leak test zone (defaults to leak_test.zone)")
+	leakCmd.Flags().Int("probes", 0, "Number of unique names to resolve (defaults to leak_test.probes)")
+	leakCmd.Flags().String("resolver", "", "Query this resolver (host:port) instead of the system resolver (defaults to leak_test.resolver)")
+	leakCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(leakCmd)
+
+	portalCmd := &cobra.Command{
+		Use:   "portal",
+		Short: "Detect a captive portal on the physical network",
+		Long:  "Fetch the captive portal probe URL over the physical interface without following redirects. Exits 1 when a portal is detected and 2 when the probe fails.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			url, _ := cmd.Flags().GetString("url")
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleTestPortal(cmd, url, format)
+		},
+	}
+	portalCmd.Flags().String("url", "", "Probe URL (defaults to captive_portal.url)")
+	portalCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(portalCmd)
+
+	firewallCmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "Check whether local firewall rules allow tunnel traffic",
+		Long:  "Inspect nftables, iptables and firewalld and report whether UDP to the WARP endpoint and traffic on the tunnel interface are allowed. Exits 1 when a rule blocks tunnel traffic.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			return c.handleTestFirewall(format)
+		},
+	}
+	firewallCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	cmd.AddCommand(firewallCmd)
+
+	return cmd
+}
+
+func (c *CLI) handleTestConnectivity(cmd *cobra.Command) error {
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔍 Testing network connectivity via %s...\n", netManager.Binding())
+
+	results := netManager.RunConnectivityTests(c.config.Cloudflare.TraceURL)
+
+	failed := 0
+	for _, result := range results {
+		status := "✅ PASS"
+		if !result.Passed {
+			status = "❌ FAIL"
+			failed++
+		}
+		if result.Detail != "" {
+			fmt.Printf("  %s %s (%s)\n", status, result.Name, result.Detail)
+		} else {
+			fmt.Printf("  %s %s\n", status, result.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d connectivity tests failed", failed, len(results))
+	}
+
+	fmt.Println("\n🎉 All connectivity tests passed!")
+	return nil
+}
+
+func (c *CLI) handleTestTunnel(cmd *cobra.Command, traceURL, format string) error {
+	if traceURL == "" {
+		traceURL = c.config.Cloudflare.TraceURL
+	}
+
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+
+	trace, err := netManager.VerifyTunnel(traceURL)
+	if trace == nil {
+		return err
+	}
+
+	if format == "json" {
+		jsonData, jerr := json.MarshalIndent(trace, "", "  ")
+		if jerr != nil {
+			return fmt.Errorf("failed to marshal trace: %w", jerr)
+		}
+		fmt.Println(string(jsonData))
+		return err
+	}
+
+	fmt.Printf("🔎 Tracing via %s\n", trace.URL)
+	fmt.Printf("  WARP:     %s\n", trace.Warp)
+	fmt.Printf("  IP:       %s\n", trace.IP)
+	fmt.Printf("  Colo:     %s\n", trace.Colo)
+	fmt.Printf("  Location: %s\n", trace.Loc)
+
+	if err != nil {
+		return err
+	}
+	fmt.Println("\n✅ Traffic is egressing via Cloudflare WARP")
+	return nil
+}
+
+func (c *CLI) handleTestLatency(cmd *cobra.Command) error {
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+
+	opts := probeOptions(cmd)
+	format, _ := cmd.Flags().GetString("format")
+
+	if format != "json" {
+		fmt.Printf("⏱️  Testing latency via %s (%d probes)...\n", netManager.Binding(), opts.Count)
+	}
+
+	results := netManager.MeasureLatency(network.LatencyTargets(netManager.Targets()), opts)
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal latency results: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	fmt.Printf("\n  %-20s %5s %7s %9s %9s %9s %9s %9s\n",
+		"Target", "Recv", "Loss", "Min", "Avg", "Max", "StdDev", "Jitter")
+	for _, stats := range results {
+		if stats.Received == 0 {
+			fmt.Printf("  %-20s %2d/%-2d %6.1f%%  %s\n", stats.Target, stats.Received, stats.Sent, stats.Loss, stats.Error)
+			continue
+		}
+		fmt.Printf("  %-20s %2d/%-2d %6.1f%% %9s %9s %9s %9s %9s\n",
+			stats.Target, stats.Received, stats.Sent, stats.Loss,
+			formatLatency(stats.Min), formatLatency(stats.Avg), formatLatency(stats.Max),
+			formatLatency(stats.StdDev), formatLatency(stats.Jitter))
+	}
+
+	return nil
+}
+
+func (c *CLI) handleCompareLatency(cmd *cobra.Command) error {
+	managers, err := c.comparePaths(cmd)
+	if err != nil {
+		return err
+	}
+
+	opts := probeOptions(cmd)
+
+	fmt.Println("⏱️  Comparing latency across paths...")
+
+	var columns [][]network.LatencyStats
+	for _, m := range managers {
+		columns = append(columns, m.MeasureLatency(network.LatencyTargets(m.Targets()), opts))
+	}
+
+	printPathHeader(managers)
+	for i, stats := range columns[0] {
+		fmt.Printf("  %-24s", stats.Target)
+		for _, results := range columns {
+			cell := "timeout"
+			if results[i].Received > 0 {
+				cell = fmt.Sprintf("%s (%.0f%%)", formatLatency(results[i].Avg), results[i].Loss)
+			}
+			fmt.Printf(" %-16s", cell)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func probeOptions(cmd *cobra.Command) network.ProbeOptions {
+	opts := network.DefaultProbeOptions()
+	opts.Protocol, _ = cmd.Flags().GetString("protocol")
+	opts.Count, _ = cmd.Flags().GetInt("count")
+	opts.Interval, _ = cmd.Flags().GetDuration("interval")
+	opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+	opts.Port, _ = cmd.Flags().GetInt("port")
+	return opts
+}
+
+func (c *CLI) handleCompareConnectivity(cmd *cobra.Command) error {
+	managers, err := c.comparePaths(cmd)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🔍 Comparing connectivity across paths...")
+
+	var columns [][]network.CheckResult
+	for _, m := range managers {
+		columns = append(columns, m.RunConnectivityTests(c.config.Cloudflare.TraceURL))
+	}
+
+	printPathHeader(managers)
+	for i, result := range columns[0] {
+		fmt.Printf("  %-24s", result.Name)
+		for _, results := range columns {
+			status := "✅ PASS"
+			if !results[i].Passed {
+				status = "❌ FAIL"
+			}
+			fmt.Printf(" %-16s", status)
+		}
+		fmt.Println()
+	}
+
+	failed, total := 0, 0
+	for _, results := range columns {
+		for _, result := range results {
+			total++
+			if !result.Passed {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d connectivity tests failed", failed, total)
+	}
+	return nil
+}
+
+// testManager builds a network manager honouring the flags shared by every
+// test subcommand: --target, --bind-interface and --bind-source.
+func (c *CLI) testManager(cmd *cobra.Command) (*network.Manager, error) {
+	netManager, err := c.baseTestManager(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	device, _ := cmd.Flags().GetString("bind-interface")
+	source, _ := cmd.Flags().GetString("bind-source")
+
+	binding := network.Binding{Device: device, Source: source}
+	if binding.IsZero() {
+		return netManager, nil
+	}
+	return netManager.WithBinding(binding)
+}
+
+// comparePaths returns one manager bound to the tunnel interface and one
+// bound to the physical interface.
+func (c *CLI) comparePaths(cmd *cobra.Command) ([]*network.Manager, error) {
+	physical, _ := cmd.Flags().GetString("physical")
+	if physical == "" {
+		var err error
+		physical, err = network.DefaultRouteInterface()
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect physical interface: %w", err)
+		}
+	}
+
+	netManager, err := c.baseTestManager(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var managers []*network.Manager
+	for _, device := range []string{c.config.Network.Interface, physical} {
+		bound, err := netManager.WithBinding(network.Binding{Device: device})
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, bound)
+	}
+	return managers, nil
+}
+
+func (c *CLI) baseTestManager(cmd *cobra.Command) (*network.Manager, error) {
+	selected, _ := cmd.Flags().GetStringSlice("target")
+	targets, err := c.testTargets(selected)
+	if err != nil {
+		return nil, err
+	}
+
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+	netManager.SetTargets(targets)
+	netManager.SetFamily(c.testFamily(cmd))
+	return netManager, nil
+}
+
+// testFamily picks the address family from --ipv4 and --ipv6. Without
+// either, IPv6 is only tested when network.ipv6 is "enable" and the system
+// has an IPv6 default route, and IPv4 is skipped on IPv6-only systems.
+func (c *CLI) testFamily(cmd *cobra.Command) string {
+	ipv4, _ := cmd.Flags().GetBool("ipv4")
+	ipv6, _ := cmd.Flags().GetBool("ipv6")
+	switch {
+	case ipv4 && ipv6:
+		return ""
+	case ipv4:
+		return "4"
+	case ipv6:
+		return "6"
+	case c.config.Network.IPv6 != "enable" || !network.HasDefaultRoute("inet6"):
+		return "4"
+	case !network.HasDefaultRoute("inet"):
+		return "6"
+	}
+	return ""
+}
+
+// testTargets returns the configured targets, or the ones selected on the
+// command line. A selection is either the name of a configured target or an
+// ad-hoc target: a bare host (icmp), host:port (tcp) or an http(s) URL.
+func (c *CLI) testTargets(selected []string) ([]network.Target, error) {
+	configured := make([]network.Target, 0, len(c.config.Tests.Targets))
+	for _, t := range c.config.Tests.Targets {
+		configured = append(configured, network.Target{
+			Name:     t.Name,
+			Host:     t.Host,
+			Port:     t.Port,
+			Protocol: t.Protocol,
+			Expect:   t.Expect,
+		})
+	}
+	if len(selected) == 0 {
+		return configured, nil
+	}
+
+	var targets []network.Target
+	for _, sel := range selected {
+		if target, ok := findTarget(configured, sel); ok {
+			targets = append(targets, target)
+			continue
+		}
+
+		target := network.Target{Host: sel, Protocol: "icmp"}
+		if strings.HasPrefix(sel, "http://") || strings.HasPrefix(sel, "https://") {
+			target.Protocol = "http"
+		} else if host, port, err := net.SplitHostPort(sel); err == nil {
+			portNum, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target %q", sel)
+			}
+			target = network.Target{Host: host, Port: portNum, Protocol: "tcp"}
+		}
+		target.Name = sel
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func findTarget(targets []network.Target, name string) (network.Target, bool) {
+	for _, target := range targets {
+		if strings.EqualFold(target.Name, name) {
+			return target, true
+		}
+	}
+	return network.Target{}, false
+}
+
+func addCompareFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("compare", false, "Compare the tunnel path with the physical path side by side")
+	cmd.Flags().String("physical", "", "Physical interface to compare against (defaults to the default route interface)")
+}
+
+func printPathHeader(managers []*network.Manager) {
+	fmt.Printf("\n  %-24s", "")
+	for _, m := range managers {
+		fmt.Printf(" %-16s", m.Binding())
+	}
+	fmt.Println()
+}
+
+func formatLatency(latency time.Duration) string {
+	if latency < 0 {
+		return "timeout"
+	}
+	if latency < time.Millisecond {
+		return latency.Round(time.Microsecond).String()
+	}
+	return latency.Round(100 * time.Microsecond).String()
+}
+
+func (c *CLI) handleTestMTU(cmd *cobra.Command) error {
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	maxMTU, _ := cmd.Flags().GetInt("max")
+	write, _ := cmd.Flags().GetBool("write")
+	apply, _ := cmd.Flags().GetBool("apply")
+	format, _ := cmd.Flags().GetString("format")
+
+	if endpoint == "" {
+		endpoint = c.config.Cloudflare.WarpEndpoint
+	}
+
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+	// Probes through a running tunnel would measure the tunnel MTU itself.
+	if netManager.Binding().IsZero() {
+		if netManager, err = netManager.PhysicalPath(); err != nil {
+			return err
+		}
+	}
+
+	if format != "json" {
+		fmt.Printf("📏 Discovering path MTU to %s via %s...\n", endpoint, netManager.Binding())
+	}
+
+	result, err := netManager.DiscoverPathMTU(endpoint, maxMTU)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal MTU result: %w", err)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		family := "IPv4"
+		if result.IPv6 {
+			family = "IPv6"
+		}
+		fmt.Printf("  Endpoint:    %s (%s, %s)\n", result.Address, family, endpoint)
+		fmt.Printf("  Path MTU:    %d (%d probes)\n", result.PathMTU, result.Probes)
+		fmt.Printf("  Overhead:    %d (outer IP + UDP + WireGuard)\n", result.Overhead)
+		fmt.Printf("  Tunnel MTU:  %d\n", result.TunnelMTU)
+		if result.Warning != "" {
+			fmt.Printf("  ⚠️  %s\n", result.Warning)
+		}
+	}
+
+	if (write || apply) && c.config.Network.IPv6 == "enable" && result.TunnelMTU < 1280 {
+		return fmt.Errorf("not using MTU %d: IPv6 needs at least 1280 (set network.ipv6 to disable or block first)", result.TunnelMTU)
+	}
+
+	if write {
+		updated := *c.config
+		updated.Network.MTU = result.TunnelMTU
+		if err := updated.Validate(); err != nil {
+			return fmt.Errorf("not saving network.mtu = %d: %w", result.TunnelMTU, err)
+		}
+		if err := updated.Save(c.configPath); err != nil {
+			return err
+		}
+		c.config = &updated
+		if format != "json" {
+			fmt.Printf("✅ Saved network.mtu = %d to %s\n", result.TunnelMTU, c.configPath)
+		}
+	}
+
+	if apply {
+		if err := netManager.SetInterfaceMTU(result.TunnelMTU); err != nil {
+			return err
+		}
+		if format != "json" {
+			fmt.Printf("✅ Applied MTU %d to %s\n", result.TunnelMTU, c.config.Network.Interface)
+		}
+	}
+
+	return nil
+}
+
+func (c *CLI) handleTestSpeed(cmd *cobra.Command) error {
+	opts := network.SpeedOptions{
+		URL:           c.config.SpeedTest.URL,
+		DownloadBytes: c.config.SpeedTest.DownloadBytes,
+		UploadBytes:   c.config.SpeedTest.UploadBytes,
+		Rounds:        c.config.SpeedTest.Rounds,
+		Timeout:       network.DefaultSpeedOptions().Timeout,
+	}
+	if url, _ := cmd.Flags().GetString("url"); url != "" {
+		opts.URL = url
+	}
+	if n, _ := cmd.Flags().GetInt64("download-bytes"); n > 0 {
+		opts.DownloadBytes = n
+	}
+	if n, _ := cmd.Flags().GetInt64("upload-bytes"); n > 0 {
+		opts.UploadBytes = n
+	}
+	if n, _ := cmd.Flags().GetInt("rounds"); n > 0 {
+		opts.Rounds = n
+	}
+	format, _ := cmd.Flags().GetString("format")
+
+	var managers []*network.Manager
+	if compare, _ := cmd.Flags().GetBool("compare"); compare {
+		var err error
+		if managers, err = c.comparePaths(cmd); err != nil {
+			return err
+		}
+	} else {
+		netManager, err := c.testManager(cmd)
+		if err != nil {
+			return err
+		}
+		managers = append(managers, netManager)
+	}
+
+	if format != "json" {
+		fmt.Printf("🚀 Measuring throughput against %s...\n", opts.URL)
+	}
+
+	var results []*network.SpeedResult
+	var failed error
+	for _, m := range managers {
+		result, err := m.MeasureThroughput(opts)
+		if err != nil {
+			failed = fmt.Errorf("%s: %w", m.Binding(), err)
+			if result == nil {
+				result = &network.SpeedResult{Path: m.Binding().String(), URL: opts.URL, Errors: []string{err.Error()}}
+			}
+		}
+		results = append(results, result)
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal speed results: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return failed
+	}
+
+	fmt.Printf("\n  %-20s %14s %14s %12s %12s\n", "Path", "Download", "Upload", "Idle", "Loaded")
+	for _, result := range results {
+		fmt.Printf("  %-20s %9.1f Mbps %9.1f Mbps %12s %12s\n", result.Path,
+			result.DownloadMbps, result.UploadMbps,
+			formatLatency(result.IdleLatency), formatLatency(result.LoadedLatency))
+		for _, e := range result.Errors {
+			fmt.Printf("    ⚠️  %s\n", e)
+		}
+	}
+
+	return failed
+}
+
+func (c *CLI) handleTestDNS(cmd *cobra.Command, domains []string, compareSystem bool, format string) error {
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+
+	if format != "json" {
+		fmt.Println("🌐 Testing DNS resolution...")
+	}
+
+	if len(domains) == 0 {
+		domains = c.config.Tests.DNSDomains
+	}
+
+	report, err := netManager.TestDNS(domains, compareSystem)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal DNS report: %w", err)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		c.printDNSReport(report)
+	}
+
+	if failures := report.Failures(); failures > 0 {
+		return fmt.Errorf("%d of %d DNS queries failed", failures, len(report.Results))
+	}
+	return nil
+}
+
+func (c *CLI) printDNSReport(report *network.DNSReport) {
+	server := ""
+	for _, result := range report.Results {
+		if result.Server != server {
+			server = result.Server
+			fmt.Printf("\n  Server %s\n", server)
+		}
+
+		status := "✅"
+		detail := strings.Join(result.Answers, ", ")
+		if problem := result.Problem(); problem != "" {
+			status = "⚠️ "
+			if !result.OK() {
+				status = "❌"
+			}
+			detail = problem
+		}
+		fmt.Printf("    %s %-24s %-4s %8s  %s\n", status, result.Domain, result.Type,
+			result.Latency.Round(time.Millisecond), detail)
+	}
+
+	if len(report.Comparisons) == 0 {
+		return
+	}
+
+	fmt.Println("\n  System resolver comparison")
+	for _, comparison := range report.Comparisons {
+		switch {
+		case comparison.Error != "":
+			fmt.Printf("    ❌ %-24s %-4s %s\n", comparison.Domain, comparison.Type, comparison.Error)
+		case comparison.Match:
+			fmt.Printf("    ✅ %-24s %-4s answers agree\n", comparison.Domain, comparison.Type)
+		default:
+			fmt.Printf("    ⚠️  %-24s %-4s system answered %s\n", comparison.Domain, comparison.Type,
+				strings.Join(comparison.System, ", "))
+		}
+	}
+
+	if len(report.UnknownResolvers) > 0 {
+		fmt.Printf("\n  ⚠️  Possible DNS leak: system uses resolvers outside the configuration: %s\n",
+			strings.Join(report.UnknownResolvers, ", "))
+	}
+}
+
+func (c *CLI) handleTestLeak(cmd *cobra.Command, zone string, probes int, resolver, format string) error {
+	if zone == "" {
+		zone = c.config.LeakTest.Zone
+	}
+	if probes <= 0 {
+		probes = c.config.LeakTest.Probes
+	}
+	if resolver == "" {
+		resolver = c.config.LeakTest.Resolver
+	}
+
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+
+	if format != "json" {
+		fmt.Printf("🕵️  Testing for DNS leaks via %s...\n", zone)
+	}
+
+	report, err := netManager.TestDNSLeak(zone, probes, c.config.LeakTest.ExpectedResolvers, resolver)
+	if report == nil {
+		return &ExitError{Code: 2, Err: err}
+	}
+
+	if format == "json" {
+		jsonData, jerr := json.MarshalIndent(report, "", "  ")
+		if jerr != nil {
+			return fmt.Errorf("failed to marshal leak report: %w", jerr)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		fmt.Printf("  Probes answered: %d/%d\n", report.Answered, report.Probes)
+		for _, resolver := range report.Resolvers {
+			status := "✅"
+			if !resolver.Expected {
+				status = "❌"
+			}
+			fmt.Printf("  %s %-40s %d queries\n", status, resolver.Address, resolver.Queries)
+		}
+	}
+
+	if err != nil {
+		return &ExitError{Code: 2, Err: err}
+	}
+	if report.Leaked() {
+		return &ExitError{Code: 1, Err: fmt.Errorf("DNS leak detected: queries answered by resolvers outside the tunnel")}
+	}
+
+	if format != "json" {
+		fmt.Println("\n🎉 No DNS leaks detected")
+	}
+	return nil
+}
+
+func (c *CLI) handleTestPortal(cmd *cobra.Command, url, format string) error {
+	if url == "" {
+		url = c.config.CaptivePortal.URL
+	}
+
+	netManager, err := c.testManager(cmd)
+	if err != nil {
+		return err
+	}
+	if netManager.Binding().IsZero() {
+		if netManager, err = netManager.PhysicalPath(); err != nil {
+			return err
+		}
+	}
+
+	if format != "json" {
+		fmt.Printf("🏨 Probing %s via %s...\n", url, netManager.Binding())
+	}
+
+	result, err := netManager.DetectCaptivePortal(url, c.config.CaptivePortal.ExpectStatus, c.config.CaptivePortal.ExpectBody)
+	if err != nil {
+		return &ExitError{Code: 2, Err: err}
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal portal result: %w", err)
+		}
+		fmt.Println(string(jsonData))
+	}
+
+	if result.Detected {
+		return &ExitError{Code: 1, Err: fmt.Errorf("captive portal detected (%s): log in at %s", result.Detail, result.PortalURL)}
+	}
+
+	if format != "json" {
+		fmt.Printf("✅ No captive portal (%s)\n", result.Detail)
+	}
+	return nil
+}
+
+func (c *CLI) handleTestFirewall(format string) error {
+	netManager := network.NewManager(c.config.Network.Interface, c.config.Network.DNS)
+
+	if format != "json" {
+		fmt.Println("🧱 Inspecting firewall rules...")
+	}
+
+	report, err := netManager.CheckFirewall(c.config.Cloudflare.WarpEndpoint)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal firewall report: %w", err)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		if len(report.Backends) > 0 {
+			fmt.Printf("  Backends: %s\n", strings.Join(report.Backends, ", "))
+		}
+		if report.FirewalldZone != "" {
+			fmt.Printf("  firewalld zone: %s\n", report.FirewalldZone)
+		}
+		for _, check := range report.Checks {
+			status := "✅"
+			switch check.Verdict {
+			case network.VerdictBlocked:
+				status = "❌"
+			case network.VerdictUncertain:
+				status = "⚠️ "
+			}
+			fmt.Printf("  %s %-16s %-32s %s\n", status, check.Backend, check.Traffic, check.Rule)
+		}
+		for _, note := range report.Notes {
+			fmt.Printf("  ℹ️  %s\n", note)
+		}
+	}
+
+	if blocked := report.Blocked(); len(blocked) > 0 {
+		return &ExitError{Code: 1, Err: fmt.Errorf("%s blocks %s", blocked[0].Backend, blocked[0].Traffic)}
+	}
+
+	if format != "json" {
+		fmt.Println("\n🎉 Firewall allows tunnel traffic")
+	}
+	return nil
+}
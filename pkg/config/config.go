@@ -3,25 +3,107 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Cloudflare CloudflareConfig `json:"cloudflare"`
-	Network    NetworkConfig    `json:"network"`
-	Logging    LoggingConfig    `json:"logging"`
+	Cloudflare    CloudflareConfig    `json:"cloudflare"`
+	Network       NetworkConfig       `json:"network"`
+	Logging       LoggingConfig       `json:"logging"`
+	DNSStub       DNSStubConfig       `json:"dns_stub"`
+	LeakTest      LeakTestConfig      `json:"leak_test"`
+	Tests         TestsConfig         `json:"tests"`
+	SpeedTest     SpeedTestConfig     `json:"speed_test"`
+	Optimize      OptimizeConfig      `json:"optimize"`
+	Apps          AppsConfig          `json:"apps"`
+	Netns         NetnsConfig         `json:"netns"`
+	Monitor       MonitorConfig       `json:"monitor"`
+	AutoConnect   AutoConnectConfig   `json:"auto_connect"`
+	CaptivePortal CaptivePortalConfig `json:"captive_portal"`
 }
 
 type CloudflareConfig struct {
-	WarpEndpoint string `json:"warp_endpoint"`
+	WarpEndpoint     string `json:"warp_endpoint"`
+	WarpEndpointV6   string `json:"warp_endpoint_v6"`
+	TraceURL         string `json:"trace_url"`
+	APIURL           string `json:"api_url"`
+	RegistrationFile string `json:"registration_file"`
 }
 
+// NetworkConfig.IPv6 is "enable" to tunnel IPv6, "disable" to leave it on
+// the physical network or "block" to route it into the tunnel without an
+// address so it cannot leak. IPv6Guard selects how native IPv6 is blocked
+// while a tunnel without IPv6 is up: "auto", "nftables", "route" or "off".
+// A zero PersistentKeepalive turns keepalives on only behind NAT, and only
+// with AutoKeepalive; a zero ListenPort lets WireGuard pick one.
 type NetworkConfig struct {
-	Interface string `json:"interface"`
-	DNS       []string `json:"dns"`
-	MTU       int     `json:"mtu"`
-	Timeout   int     `json:"timeout"`
+	Interface           string        `json:"interface"`
+	DNS                 []string      `json:"dns"`
+	MTU                 int           `json:"mtu"`
+	Timeout             int           `json:"timeout"`
+	IPv6                string        `json:"ipv6"`
+	IPv6Guard           string        `json:"ipv6_guard"`
+	ListenPort          int           `json:"listen_port"`
+	PersistentKeepalive int           `json:"persistent_keepalive"`
+	AutoKeepalive       bool          `json:"auto_keepalive"`
+	Routing             RoutingConfig `json:"routing"`
+}
+
+// RoutingConfig controls policy routing: tunnel routes live in Table and
+// are selected by an ip rule for packets without FwMark. In "apps" mode only
+// traffic of the applications in the apps section uses the tunnel; in
+// "netns" mode only programs inside the darp network namespace do.
+type RoutingConfig struct {
+	Mode         string `json:"mode"`
+	Table        int    `json:"table"`
+	FwMark       uint32 `json:"fwmark"`
+	Priority     int    `json:"priority"`
+	SnapshotFile string `json:"snapshot_file"`
+}
+
+// AutoConnectConfig makes darp daemon connect on untrusted networks and
+// disconnect on trusted ones.
+type AutoConnectConfig struct {
+	Enabled         bool             `json:"enabled"`
+	TrustedNetworks []TrustedNetwork `json:"trusted_networks"`
+}
+
+// TrustedNetwork matches when every field that is set matches the current
+// network.
+type TrustedNetwork struct {
+	Name         string `json:"name"`
+	GatewayMAC   string `json:"gateway_mac,omitempty"`
+	Subnet       string `json:"subnet,omitempty"`
+	Interface    string `json:"interface,omitempty"`
+	SearchDomain string `json:"search_domain,omitempty"`
+}
+
+// CaptivePortalConfig describes the plain HTTP probe used to detect captive
+// portals before connecting. An empty ExpectBody skips the content check.
+type CaptivePortalConfig struct {
+	URL            string `json:"url"`
+	ExpectStatus   int    `json:"expect_status"`
+	ExpectBody     string `json:"expect_body"`
+	RecheckSeconds int    `json:"recheck_seconds"`
+}
+
+type MonitorConfig struct {
+	DebounceMS int `json:"debounce_ms"`
+}
+
+type NetnsConfig struct {
+	Name string `json:"name"`
+}
+
+type AppsConfig struct {
+	Cgroup string   `json:"cgroup"`
+	Mark   uint32   `json:"mark"`
+	Units  []string `json:"units"`
 }
 
 type LoggingConfig struct {
@@ -30,32 +112,220 @@ type LoggingConfig struct {
 	Output string `json:"output"`
 }
 
+type DNSStubConfig struct {
+	Listen     string   `json:"listen"`
+	Blocklists []string `json:"blocklists"`
+	BlockMode  string   `json:"block_mode"`
+	StatsFile  string   `json:"stats_file"`
+}
+
+type LeakTestConfig struct {
+	Zone              string   `json:"zone"`
+	Probes            int      `json:"probes"`
+	ExpectedResolvers []string `json:"expected_resolvers"`
+	Resolver          string   `json:"resolver"`
+}
+
+type TestsConfig struct {
+	Targets    []TestTarget `json:"targets"`
+	DNSDomains []string     `json:"dns_domains"`
+}
+
+type SpeedTestConfig struct {
+	URL           string `json:"url"`
+	DownloadBytes int64  `json:"download_bytes"`
+	UploadBytes   int64  `json:"upload_bytes"`
+	Rounds        int    `json:"rounds"`
+}
+
+type OptimizeConfig struct {
+	SnapshotFile   string                     `json:"snapshot_file"`
+	PersistFile    string                     `json:"persist_file"`
+	DefaultProfile string                     `json:"default_profile"`
+	Profiles       map[string]OptimizeProfile `json:"profiles"`
+}
+
+// OptimizeProfile selects the sysctl tunables, the root qdisc of the tunnel
+// interface and how its MTU is chosen: "fixed" uses network.mtu, "discover"
+// probes the path to the WARP endpoint and an empty strategy leaves it alone.
+type OptimizeProfile struct {
+	Description string            `json:"description,omitempty"`
+	Sysctl      map[string]string `json:"sysctl"`
+	Qdisc       string            `json:"qdisc,omitempty"`
+	MTU         string            `json:"mtu,omitempty"`
+}
+
+type TestTarget struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`
+	Expect   string `json:"expect,omitempty"`
+}
+
 func DefaultConfig() *Config {
 	return &Config{
 		Cloudflare: CloudflareConfig{
-			WarpEndpoint: "engage.cloudflareclient.com:2408",
+			WarpEndpoint:     "engage.cloudflareclient.com:2408",
+			WarpEndpointV6:   "[2606:4700:d0::a29f:c001]:2408",
+			TraceURL:         "https://www.cloudflare.com/cdn-cgi/trace",
+			APIURL:           "https://api.cloudflareclient.com/v0a2158",
+			RegistrationFile: "/var/lib/darp/registration.json",
 		},
 		Network: NetworkConfig{
-			Interface: "warp0",
-			DNS:       []string{"1.1.1.1", "1.0.0.1"},
-			MTU:       1280,
-			Timeout:   30,
+			Interface:     "warp0",
+			DNS:           []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001"},
+			MTU:           1280,
+			Timeout:       30,
+			IPv6:          "enable",
+			IPv6Guard:     "auto",
+			AutoKeepalive: true,
+			Routing: RoutingConfig{
+				Mode:         "full",
+				Table:        51820,
+				FwMark:       51820,
+				Priority:     32700,
+				SnapshotFile: "/var/lib/darp/routing-snapshot.json",
+			},
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
 			Output: "stdout",
 		},
+		DNSStub: DNSStubConfig{
+			Listen:     "127.0.0.1:53",
+			Blocklists: []string{},
+			BlockMode:  "nxdomain",
+			StatsFile:  "/run/darp/dns-stats.json",
+		},
+		LeakTest: LeakTestConfig{
+			Zone:   "whoami.ds.akahelp.net",
+			Probes: 6,
+			ExpectedResolvers: []string{
+				"172.64.0.0/13",
+				"162.158.0.0/15",
+				"141.101.64.0/18",
+				"108.162.192.0/18",
+				"104.16.0.0/13",
+				"104.24.0.0/14",
+				"2400:cb00::/32",
+				"2606:4700::/32",
+				"2a06:98c0::/29",
+			},
+		},
+		Tests: TestsConfig{
+			Targets: []TestTarget{
+				{Name: "Cloudflare DNS (1.1.1.1)", Host: "1.1.1.1", Protocol: "icmp"},
+				{Name: "Cloudflare DNS (1.0.0.1)", Host: "1.0.0.1", Protocol: "icmp"},
+				{Name: "Google DNS (8.8.8.8)", Host: "8.8.8.8", Protocol: "icmp"},
+				{Name: "Google DNS (8.8.4.4)", Host: "8.8.4.4", Protocol: "icmp"},
+				{Name: "Cloudflare HTTP", Host: "1.1.1.1", Port: 80, Protocol: "tcp"},
+				{Name: "Cloudflare DNS (2606:4700:4700::1111)", Host: "2606:4700:4700::1111", Protocol: "icmp"},
+				{Name: "Cloudflare DNS (2606:4700:4700::1001)", Host: "2606:4700:4700::1001", Protocol: "icmp"},
+				{Name: "Google DNS (2001:4860:4860::8888)", Host: "2001:4860:4860::8888", Protocol: "icmp"},
+				{Name: "Google DNS (2001:4860:4860::8844)", Host: "2001:4860:4860::8844", Protocol: "icmp"},
+				{Name: "Cloudflare HTTP (IPv6)", Host: "2606:4700:4700::1111", Port: 80, Protocol: "tcp"},
+			},
+			DNSDomains: []string{"cloudflare.com", "google.com", "github.com", "archlinux.org"},
+		},
+		SpeedTest: SpeedTestConfig{
+			URL:           "https://speed.cloudflare.com",
+			DownloadBytes: 25 << 20,
+			UploadBytes:   10 << 20,
+			Rounds:        3,
+		},
+		Netns: NetnsConfig{
+			Name: "darp",
+		},
+		Monitor: MonitorConfig{
+			DebounceMS: 3000,
+		},
+		AutoConnect: AutoConnectConfig{
+			Enabled:         false,
+			TrustedNetworks: []TrustedNetwork{},
+		},
+		CaptivePortal: CaptivePortalConfig{
+			URL:            "http://cp.cloudflare.com/",
+			ExpectStatus:   204,
+			RecheckSeconds: 30,
+		},
+		Apps: AppsConfig{
+			Cgroup: "darp",
+			Mark:   51821,
+			Units:  []string{},
+		},
+		Optimize: OptimizeConfig{
+			SnapshotFile:   "/var/lib/darp/sysctl-snapshot.json",
+			PersistFile:    "/etc/sysctl.d/99-darp.conf",
+			DefaultProfile: "balanced",
+			Profiles: map[string]OptimizeProfile{
+				"balanced": {
+					Description: "BBR, fair queueing and larger socket buffers",
+					Sysctl: map[string]string{
+						"net.ipv4.tcp_congestion_control": "bbr",
+						"net.core.default_qdisc":          "fq",
+						"net.core.rmem_max":               "134217728",
+						"net.core.wmem_max":               "134217728",
+						"net.ipv4.tcp_mtu_probing":        "1",
+					},
+					MTU: "fixed",
+				},
+				"throughput": {
+					Description: "Large TCP windows for bulk transfers on fast links",
+					Sysctl: map[string]string{
+						"net.ipv4.tcp_congestion_control":    "bbr",
+						"net.core.default_qdisc":             "fq",
+						"net.core.rmem_max":                  "268435456",
+						"net.core.wmem_max":                  "268435456",
+						"net.ipv4.tcp_rmem":                  "4096 131072 268435456",
+						"net.ipv4.tcp_wmem":                  "4096 65536 268435456",
+						"net.ipv4.tcp_mtu_probing":           "1",
+						"net.ipv4.tcp_slow_start_after_idle": "0",
+					},
+					Qdisc: "fq",
+					MTU:   "discover",
+				},
+				"low-latency": {
+					Description: "Small queues for gaming and calls",
+					Sysctl: map[string]string{
+						"net.ipv4.tcp_congestion_control": "bbr",
+						"net.core.default_qdisc":          "fq_codel",
+						"net.ipv4.tcp_notsent_lowat":      "16384",
+						"net.ipv4.tcp_fastopen":           "3",
+						"net.ipv4.tcp_mtu_probing":        "1",
+					},
+					Qdisc: "fq_codel",
+					MTU:   "discover",
+				},
+				"battery": {
+					Description: "Kernel defaults with MTU probing only",
+					Sysctl: map[string]string{
+						"net.ipv4.tcp_mtu_probing": "1",
+					},
+					MTU: "fixed",
+				},
+			},
+		},
+	}
+}
+
+// ResolvePath returns configPath, or the per-user default when it is empty.
+func ResolvePath(configPath string) (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(homeDir, ".config", "darp", "config.json"), nil
 }
 
 func LoadConfig(configPath string) (*Config, error) {
-	if configPath == "" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return DefaultConfig(), nil
-		}
-		configPath = filepath.Join(homeDir, ".config", "darp", "config.json")
+	configPath, err := ResolvePath(configPath)
+	if err != nil {
+		return DefaultConfig(), nil
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -76,12 +346,12 @@ func LoadConfig(configPath string) (*Config, error) {
 		return DefaultConfig(), fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
+	config := DefaultConfig()
+	if err := json.Unmarshal(data, config); err != nil {
 		return DefaultConfig(), fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	return &config, nil
+	return config, nil
 }
 
 func (c *Config) Save(configPath string) error {
@@ -104,5 +374,190 @@ func (c *Config) Validate() error {
 	if c.Cloudflare.WarpEndpoint == "" {
 		return fmt.Errorf("WARP endpoint must be configured")
 	}
+	if c.Cloudflare.WarpEndpointV6 != "" {
+		host, _, err := net.SplitHostPort(c.Cloudflare.WarpEndpointV6)
+		if ip := net.ParseIP(host); err != nil || ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid warp_endpoint_v6 %q (expected [IPv6 address]:port)", c.Cloudflare.WarpEndpointV6)
+		}
+	}
+	switch c.Network.IPv6 {
+	case "enable", "disable", "block":
+	default:
+		return fmt.Errorf("invalid network ipv6 mode %q (expected enable, disable or block)", c.Network.IPv6)
+	}
+	if c.Network.IPv6 == "enable" && c.Network.MTU < 1280 {
+		return fmt.Errorf("network mtu %d is too small for IPv6 (at least 1280, or set network.ipv6 to disable or block)", c.Network.MTU)
+	}
+	switch c.Network.IPv6Guard {
+	case "auto", "nftables", "route", "off":
+	default:
+		return fmt.Errorf("invalid network ipv6_guard %q (expected auto, nftables, route or off)", c.Network.IPv6Guard)
+	}
+	if !validInterfaceName(c.Network.Interface) {
+		return fmt.Errorf("invalid network interface name %q (1-15 characters, no '/', ':' or whitespace)", c.Network.Interface)
+	}
+	if c.Network.MTU < 576 || c.Network.MTU > 9000 {
+		return fmt.Errorf("invalid network mtu %d (must be between 576 and 9000)", c.Network.MTU)
+	}
+	if c.Network.Timeout <= 0 {
+		return fmt.Errorf("network timeout must be positive")
+	}
+	if u, err := url.Parse(c.Cloudflare.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid api_url %q (expected an http or https URL)", c.Cloudflare.APIURL)
+	}
+	if _, _, err := net.SplitHostPort(c.Cloudflare.WarpEndpoint); err != nil {
+		return fmt.Errorf("invalid warp_endpoint %q (expected host:port)", c.Cloudflare.WarpEndpoint)
+	}
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid logging level %q (expected debug, info, warn or error)", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "json", "text":
+	default:
+		return fmt.Errorf("invalid logging format %q (expected json or text)", c.Logging.Format)
+	}
+	if out := c.Logging.Output; out != "stdout" && out != "stderr" && !filepath.IsAbs(out) {
+		return fmt.Errorf("invalid logging output %q (expected stdout, stderr or an absolute file path)", out)
+	}
+	if c.Network.ListenPort < 0 || c.Network.ListenPort > 65535 {
+		return fmt.Errorf("invalid network listen_port %d (must be between 0 and 65535)", c.Network.ListenPort)
+	}
+	if c.Network.PersistentKeepalive < 0 || c.Network.PersistentKeepalive > 65535 {
+		return fmt.Errorf("invalid network persistent_keepalive %d (must be between 0 and 65535 seconds)", c.Network.PersistentKeepalive)
+	}
+	routing := c.Network.Routing
+	if routing.Table <= 0 || routing.Table >= 253 && routing.Table <= 255 {
+		return fmt.Errorf("invalid routing table %d (must be positive and not default, main or local)", routing.Table)
+	}
+	if routing.FwMark == 0 {
+		return fmt.Errorf("routing fwmark must be non-zero")
+	}
+	if routing.Priority < 2 || routing.Priority >= 32766 {
+		return fmt.Errorf("invalid routing rule priority %d (must be between 2 and 32765)", routing.Priority)
+	}
+	switch routing.Mode {
+	case "full", "apps", "netns":
+	default:
+		return fmt.Errorf("invalid routing mode %q (expected full, apps or netns)", routing.Mode)
+	}
+	if c.Netns.Name == "" || strings.ContainsAny(c.Netns.Name, "/ ") {
+		return fmt.Errorf("invalid netns name %q", c.Netns.Name)
+	}
+	if c.Monitor.DebounceMS < 0 {
+		return fmt.Errorf("monitor debounce_ms must not be negative")
+	}
+	if !strings.HasPrefix(c.CaptivePortal.URL, "http://") {
+		return fmt.Errorf("captive_portal url must be plain http:// so a portal can intercept it")
+	}
+	if c.CaptivePortal.ExpectStatus < 100 || c.CaptivePortal.ExpectStatus > 599 {
+		return fmt.Errorf("invalid captive_portal expect_status %d", c.CaptivePortal.ExpectStatus)
+	}
+	if c.CaptivePortal.RecheckSeconds <= 0 {
+		return fmt.Errorf("captive_portal recheck_seconds must be positive")
+	}
+	trusted := make(map[string]bool)
+	for _, network := range c.AutoConnect.TrustedNetworks {
+		if err := network.Validate(); err != nil {
+			return err
+		}
+		if trusted[network.Name] {
+			return fmt.Errorf("duplicate trusted network name %q", network.Name)
+		}
+		trusted[network.Name] = true
+	}
+	if c.Apps.Mark == 0 || c.Apps.Mark == routing.FwMark {
+		return fmt.Errorf("apps mark must be non-zero and differ from the routing fwmark")
+	}
+	if c.Apps.Cgroup == "" || strings.Contains(c.Apps.Cgroup, "..") {
+		return fmt.Errorf("invalid apps cgroup %q", c.Apps.Cgroup)
+	}
+	switch c.DNSStub.BlockMode {
+	case "nxdomain", "zero":
+	default:
+		return fmt.Errorf("invalid dns_stub block_mode %q (expected nxdomain or zero)", c.DNSStub.BlockMode)
+	}
+	if r := c.LeakTest.Resolver; r != "" && net.ParseIP(r) == nil {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			return fmt.Errorf("invalid leak_test resolver %q: must be an address or host:port", r)
+		}
+	}
+	for _, cidr := range c.LeakTest.ExpectedResolvers {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid leak_test expected resolver network %q", cidr)
+		}
+	}
+	names := make(map[string]bool)
+	for _, target := range c.Tests.Targets {
+		if err := target.Validate(); err != nil {
+			return err
+		}
+		if names[target.Name] {
+			return fmt.Errorf("duplicate test target name %q", target.Name)
+		}
+		names[target.Name] = true
+	}
+	if _, ok := c.Optimize.Profiles[c.Optimize.DefaultProfile]; c.Optimize.DefaultProfile != "" && !ok {
+		return fmt.Errorf("optimize default_profile %q is not defined", c.Optimize.DefaultProfile)
+	}
+	for name, profile := range c.Optimize.Profiles {
+		switch profile.MTU {
+		case "", "fixed", "discover":
+		default:
+			return fmt.Errorf("optimize profile %q has invalid mtu strategy %q (expected fixed or discover)", name, profile.MTU)
+		}
+	}
+	return nil
+}
+
+func (t TestTarget) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("test target for %q has no name", t.Host)
+	}
+	if t.Host == "" {
+		return fmt.Errorf("test target %q has no host", t.Name)
+	}
+	switch t.Protocol {
+	case "icmp", "http":
+	case "tcp", "udp":
+		if t.Port <= 0 || t.Port > 65535 {
+			return fmt.Errorf("test target %q needs a port for protocol %s", t.Name, t.Protocol)
+		}
+	default:
+		return fmt.Errorf("test target %q has unsupported protocol %q (expected icmp, tcp, udp or http)", t.Name, t.Protocol)
+	}
+	switch t.Expect {
+	case "", "reachable", "unreachable":
+	default:
+		if _, err := strconv.Atoi(t.Expect); err != nil || t.Protocol != "http" {
+			return fmt.Errorf("test target %q has invalid expect %q", t.Name, t.Expect)
+		}
+	}
 	return nil
 }
+
+func (t TrustedNetwork) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("trusted network has no name")
+	}
+	if t.GatewayMAC == "" && t.Subnet == "" && t.Interface == "" && t.SearchDomain == "" {
+		return fmt.Errorf("trusted network %q has no match criteria", t.Name)
+	}
+	if t.GatewayMAC != "" {
+		if _, err := net.ParseMAC(t.GatewayMAC); err != nil {
+			return fmt.Errorf("trusted network %q has invalid gateway_mac %q", t.Name, t.GatewayMAC)
+		}
+	}
+	if t.Subnet != "" {
+		if _, _, err := net.ParseCIDR(t.Subnet); err != nil {
+			return fmt.Errorf("trusted network %q has invalid subnet %q", t.Name, t.Subnet)
+		}
+	}
+	return nil
+}
+
+// validInterfaceName follows the kernel's rules for network device names.
+func validInterfaceName(name string) bool {
+	return name != "" && len(name) < 16 && name != "." && name != ".." && !strings.ContainsAny(name, "/: \t\n")
+}
@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Blocklist struct {
+	mu      sync.RWMutex
+	paths   []string
+	domains map[string]struct{}
+}
+
+func NewBlocklist(paths []string) *Blocklist {
+	return &Blocklist{
+		paths:   paths,
+		domains: make(map[string]struct{}),
+	}
+}
+
+// Load reads every configured list and swaps it in atomically, so a bad file
+// on reload leaves the previous list in place.
+func (b *Blocklist) Load() error {
+	domains := make(map[string]struct{})
+
+	for _, path := range b.paths {
+		if err := loadListFile(path, domains); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	b.domains = domains
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Blocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.domains)
+}
+
+// Match reports the listed domain covering name, checking the name itself
+// and then each parent domain.
+func (b *Blocklist) Match(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for name != "" {
+		if _, ok := b.domains[name]; ok {
+			return name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return "", false
+}
+
+func loadListFile(path string, domains map[string]struct{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open blocklist %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Hosts format lists an address followed by one or more names,
+		// domain lists carry a bare name per line.
+		names := fields[:1]
+		if net.ParseIP(fields[0]) != nil {
+			names = fields[1:]
+		}
+
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if name == "" || name == "localhost" || name == "localhost.localdomain" {
+				continue
+			}
+			domains[name] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read blocklist %s: %w", path, err)
+	}
+	return nil
+}
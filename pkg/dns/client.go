@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+type Client struct {
+	Timeout time.Duration
+	Dial    func(network, address string) (net.Conn, error)
+}
+
+func NewClient(timeout time.Duration) *Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	return &Client{
+		Timeout: timeout,
+		Dial:    dialer.Dial,
+	}
+}
+
+// Exchange sends a raw query to server over UDP, retrying over TCP when the
+// answer comes back truncated.
+func (c *Client) Exchange(server string, query []byte) ([]byte, time.Duration, error) {
+	server = ServerAddress(server)
+	start := time.Now()
+
+	reply, err := c.exchangeUDP(server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if msg, err := ParseMessage(reply); err == nil && msg.Truncated() {
+		reply, err = c.exchangeTCP(server, query)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return reply, time.Since(start), nil
+}
+
+func (c *Client) Query(server, name string, qtype uint16) (*Message, time.Duration, error) {
+	id := uint16(time.Now().UnixNano())
+	query, err := NewQuery(id, name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reply, rtt, err := c.Exchange(server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg, err := ParseMessage(reply)
+	if err != nil {
+		return nil, rtt, fmt.Errorf("failed to parse reply from %s: %w", server, err)
+	}
+	if msg.ID != id {
+		return nil, rtt, fmt.Errorf("reply from %s has mismatched id", server)
+	}
+
+	return msg, rtt, nil
+}
+
+func (c *Client) exchangeUDP(server string, query []byte) ([]byte, error) {
+	conn, err := c.Dial("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", server, err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("no reply from %s: %w", server, err)
+	}
+
+	return buf[:n], nil
+}
+
+func (c *Client) exchangeTCP(server string, query []byte) ([]byte, error) {
+	conn, err := c.Dial("tcp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", server, err)
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("no reply from %s: %w", server, err)
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, fmt.Errorf("short reply from %s: %w", server, err)
+	}
+
+	return buf, nil
+}
+
+func ServerAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
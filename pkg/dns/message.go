@@ -0,0 +1,310 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	TypeA     uint16 = 1
+	TypeCNAME uint16 = 5
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	ClassINET uint16 = 1
+
+	RcodeSuccess  = 0
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeRefused  = 5
+
+	flagQR = 1 << 15
+	flagTC = 1 << 9
+	flagRD = 1 << 8
+	flagRA = 1 << 7
+
+	headerLen = 12
+)
+
+var errShortMessage = errors.New("dns: message too short")
+
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+type Answer struct {
+	Name string
+	Type uint16
+	TTL  uint32
+	Data string
+}
+
+type Message struct {
+	ID        uint16
+	Flags     uint16
+	Questions []Question
+	Answers   []Answer
+}
+
+func (m *Message) Rcode() int {
+	return int(m.Flags & 0x000f)
+}
+
+func (m *Message) Truncated() bool {
+	return m.Flags&flagTC != 0
+}
+
+func TypeString(t uint16) string {
+	switch t {
+	case TypeA:
+		return "A"
+	case TypeAAAA:
+		return "AAAA"
+	case TypeCNAME:
+		return "CNAME"
+	case TypeTXT:
+		return "TXT"
+	default:
+		return fmt.Sprintf("TYPE%d", t)
+	}
+}
+
+func RcodeString(rcode int) string {
+	switch rcode {
+	case RcodeSuccess:
+		return "NOERROR"
+	case RcodeServFail:
+		return "SERVFAIL"
+	case RcodeNXDomain:
+		return "NXDOMAIN"
+	case RcodeRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", rcode)
+	}
+}
+
+func NewQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	encoded, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, headerLen, headerLen+len(encoded)+4)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flagRD)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	msg = append(msg, encoded...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, ClassINET)
+	return msg, nil
+}
+
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < headerLen {
+		return nil, errShortMessage
+	}
+
+	m := &Message{
+		ID:    binary.BigEndian.Uint16(b[0:]),
+		Flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+
+	off := headerLen
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errShortMessage
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next:]),
+			Class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < ancount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(b) {
+			return nil, errShortMessage
+		}
+		rtype := binary.BigEndian.Uint16(b[next:])
+		ttl := binary.BigEndian.Uint32(b[next+4:])
+		rdlen := int(binary.BigEndian.Uint16(b[next+8:]))
+		rdata := next + 10
+		if rdata+rdlen > len(b) {
+			return nil, errShortMessage
+		}
+
+		answer := Answer{Name: name, Type: rtype, TTL: ttl}
+		switch rtype {
+		case TypeA, TypeAAAA:
+			answer.Data = net.IP(b[rdata : rdata+rdlen]).String()
+		case TypeCNAME:
+			target, _, err := readName(b, rdata)
+			if err != nil {
+				return nil, err
+			}
+			answer.Data = target
+		case TypeTXT:
+			answer.Data = strings.Join(readStrings(b[rdata:rdata+rdlen]), " ")
+		}
+		m.Answers = append(m.Answers, answer)
+		off = rdata + rdlen
+	}
+
+	return m, nil
+}
+
+// BlockedResponse builds the reply for a blocked query. In "zero" mode A and
+// AAAA questions get an unspecified address, every other mode answers
+// NXDOMAIN.
+func BlockedResponse(query []byte, q Question, mode string) ([]byte, error) {
+	if mode != "zero" {
+		return buildResponse(query, q, RcodeNXDomain, nil)
+	}
+
+	var rdata [][]byte
+	switch q.Type {
+	case TypeA:
+		rdata = append(rdata, net.IPv4zero.To4())
+	case TypeAAAA:
+		rdata = append(rdata, net.IPv6zero)
+	}
+	return buildResponse(query, q, RcodeSuccess, rdata)
+}
+
+func ErrorResponse(query []byte, q Question, rcode int) ([]byte, error) {
+	return buildResponse(query, q, rcode, nil)
+}
+
+// AnswerResponse answers q with one record of the question type per rdata.
+func AnswerResponse(query []byte, q Question, rdata [][]byte) ([]byte, error) {
+	return buildResponse(query, q, RcodeSuccess, rdata)
+}
+
+// TXTData encodes strings as TXT record data.
+func TXTData(values ...string) []byte {
+	var out []byte
+	for _, v := range values {
+		if len(v) > 255 {
+			v = v[:255]
+		}
+		out = append(out, byte(len(v)))
+		out = append(out, v...)
+	}
+	return out
+}
+
+func buildResponse(query []byte, q Question, rcode int, rdata [][]byte) ([]byte, error) {
+	if len(query) < headerLen {
+		return nil, errShortMessage
+	}
+
+	encoded, err := encodeName(q.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	reqFlags := binary.BigEndian.Uint16(query[2:])
+	flags := uint16(flagQR|flagRA) | reqFlags&(0x7800|flagRD) | uint16(rcode&0x0f)
+
+	msg := make([]byte, headerLen)
+	copy(msg[0:2], query[0:2])
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	msg = append(msg, encoded...)
+	msg = binary.BigEndian.AppendUint16(msg, q.Type)
+	msg = binary.BigEndian.AppendUint16(msg, q.Class)
+
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(rdata)))
+	for _, data := range rdata {
+		msg = append(msg, 0xc0, headerLen)
+		msg = binary.BigEndian.AppendUint16(msg, q.Type)
+		msg = binary.BigEndian.AppendUint16(msg, q.Class)
+		msg = binary.BigEndian.AppendUint32(msg, 60)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+		msg = append(msg, data...)
+	}
+
+	return msg, nil
+}
+
+func encodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	var out []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("dns: invalid name %q", name)
+			}
+			out = append(out, byte(len(label)))
+			out = append(out, label...)
+		}
+	}
+	out = append(out, 0)
+	if len(out) > 255 {
+		return nil, fmt.Errorf("dns: name too long %q", name)
+	}
+	return out, nil
+}
+
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for hops := 0; ; hops++ {
+		if off >= len(b) || hops > 64 {
+			return "", 0, errShortMessage
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errShortMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		default:
+			if off+1+length > len(b) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, strings.ToLower(string(b[off+1:off+1+length])))
+			off += 1 + length
+		}
+	}
+}
+
+func readStrings(b []byte) []string {
+	var out []string
+	for len(b) > 0 {
+		n := int(b[0])
+		if 1+n > len(b) {
+			break
+		}
+		out = append(out, string(b[1:1+n]))
+		b = b[1+n:]
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestReadName(t *testing.T) {
+	// "example.com" at offset 0, then "www" pointing back at it.
+	base := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}
+	compressed := append(append([]byte{}, base...), 3, 'w', 'w', 'w', 0xc0, 0)
+
+	tests := []struct {
+		name     string
+		msg      []byte
+		off      int
+		want     string
+		wantNext int
+		wantErr  bool
+	}{
+		{"plain", base, 0, "example.com", 13, false},
+		{"root", []byte{0}, 0, "", 1, false},
+		{"lowercased", []byte{3, 'W', 'w', 'W', 0}, 0, "www", 5, false},
+		{"compressed", compressed, 13, "www.example.com", 19, false},
+		{"pointer only", []byte{0, 0xc0, 0}, 1, "", 3, false},
+		{"pointer loop", []byte{0xc0, 0}, 0, "", 0, true},
+		{"truncated pointer", []byte{0xc0}, 0, "", 0, true},
+		{"truncated label", []byte{5, 'a', 'b'}, 0, "", 0, true},
+		{"missing terminator", []byte{1, 'a'}, 0, "", 0, true},
+		{"offset past end", base, 20, "", 0, true},
+		{"pointer past end", []byte{0xc0, 0x20}, 0, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := readName(tt.msg, tt.off)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want || next != tt.wantNext {
+				t.Errorf("readName = %q, %d; want %q, %d", got, next, tt.want, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	query, err := NewQuery(0x1234, "Example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := Question{Name: "example.com", Type: TypeA, Class: ClassINET}
+	answer, err := AnswerResponse(query, q, [][]byte{{192, 0, 2, 1}, {192, 0, 2, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txtQuery, _ := NewQuery(1, "whoami.test", TypeTXT)
+	txt, err := AnswerResponse(txtQuery, Question{Name: "whoami.test", Type: TypeTXT, Class: ClassINET}, [][]byte{TXTData("ns", "198.51.100.9")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A CNAME whose target is compressed against the question name.
+	cname := append([]byte{}, query...)
+	binary.BigEndian.PutUint16(cname[6:], 1)
+	cname = append(cname, 0xc0, headerLen, 0, byte(TypeCNAME), 0, 1, 0, 0, 0, 60, 0, 6, 3, 'w', 'w', 'w', 0xc0, headerLen)
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    []Answer
+		wantErr bool
+	}{
+		{"query", query, nil, false},
+		{"compressed answers", answer, []Answer{
+			{Name: "example.com", Type: TypeA, TTL: 60, Data: "192.0.2.1"},
+			{Name: "example.com", Type: TypeA, TTL: 60, Data: "192.0.2.2"},
+		}, false},
+		{"txt", txt, []Answer{{Name: "whoami.test", Type: TypeTXT, TTL: 60, Data: "ns 198.51.100.9"}}, false},
+		{"compressed cname", cname, []Answer{{Name: "example.com", Type: TypeCNAME, TTL: 60, Data: "www.example.com"}}, false},
+		{"short header", query[:headerLen-1], nil, true},
+		{"truncated question", query[:len(query)-2], nil, true},
+		{"truncated answer header", answer[:len(answer)-8], nil, true},
+		{"truncated rdata", answer[:len(answer)-1], nil, true},
+		{"answer count too high", func() []byte {
+			b := append([]byte{}, answer...)
+			binary.BigEndian.PutUint16(b[6:], 3)
+			return b
+		}(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ParseMessage(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(msg.Answers, tt.want) {
+				t.Errorf("answers = %+v, want %+v", msg.Answers, tt.want)
+			}
+			if len(msg.Questions) != 1 {
+				t.Errorf("questions = %+v, want one", msg.Questions)
+			}
+		})
+	}
+}
+
+func TestResponseKeepsQueryID(t *testing.T) {
+	query, _ := NewQuery(0xbeef, "blocked.test", TypeA)
+	reply, err := BlockedResponse(query, Question{Name: "blocked.test", Type: TypeA, Class: ClassINET}, "nxdomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ParseMessage(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 0xbeef || msg.Rcode() != RcodeNXDomain || !bytes.Equal(reply[:2], query[:2]) {
+		t.Errorf("reply id %#x rcode %d", msg.ID, msg.Rcode())
+	}
+}
@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	listen    string
+	upstreams []string
+	blocklist *Blocklist
+	blockMode string
+	client    *Client
+
+	mu    sync.Mutex
+	conn  net.PacketConn
+	stats Stats
+}
+
+type Stats struct {
+	Queries          uint64            `json:"queries"`
+	Blocked          uint64            `json:"blocked"`
+	Forwarded        uint64            `json:"forwarded"`
+	Failed           uint64            `json:"failed"`
+	BlocklistEntries int               `json:"blocklist_entries"`
+	Hits             map[string]uint64 `json:"hits"`
+	StartedAt        time.Time         `json:"started_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+type DomainHits struct {
+	Domain string
+	Hits   uint64
+}
+
+func NewServer(listen string, upstreams []string, blocklist *Blocklist, blockMode string, timeout time.Duration) *Server {
+	return &Server{
+		listen:    listen,
+		upstreams: upstreams,
+		blocklist: blocklist,
+		blockMode: blockMode,
+		client:    NewClient(timeout),
+		stats: Stats{
+			Hits:      make(map[string]uint64),
+			StartedAt: time.Now(),
+		},
+	}
+}
+
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.listen, err)
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	log.Printf("DNS stub listening on %s (%d blocked domains)", s.listen, s.blocklist.Len())
+
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read query: %w", err)
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go s.handle(conn, addr, query)
+	}
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Server) Reload() error {
+	if err := s.blocklist.Load(); err != nil {
+		return err
+	}
+	log.Printf("DNS blocklists reloaded (%d blocked domains)", s.blocklist.Len())
+	return nil
+}
+
+func (s *Server) handle(conn net.PacketConn, addr net.Addr, query []byte) {
+	msg, err := ParseMessage(query)
+	if err != nil || len(msg.Questions) != 1 {
+		return
+	}
+	q := msg.Questions[0]
+
+	s.count(func(st *Stats) { st.Queries++ })
+
+	if domain, blocked := s.blocklist.Match(q.Name); blocked {
+		reply, err := BlockedResponse(query, q, s.blockMode)
+		if err != nil {
+			return
+		}
+		s.count(func(st *Stats) {
+			st.Blocked++
+			st.Hits[domain]++
+		})
+		conn.WriteTo(reply, addr)
+		return
+	}
+
+	for _, upstream := range s.upstreams {
+		reply, _, err := s.client.Exchange(upstream, query)
+		if err != nil {
+			continue
+		}
+		s.count(func(st *Stats) { st.Forwarded++ })
+		conn.WriteTo(reply, addr)
+		return
+	}
+
+	s.count(func(st *Stats) { st.Failed++ })
+	if reply, err := ErrorResponse(query, q, RcodeServFail); err == nil {
+		conn.WriteTo(reply, addr)
+	}
+}
+
+func (s *Server) count(update func(*Stats)) {
+	s.mu.Lock()
+	update(&s.stats)
+	s.mu.Unlock()
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Hits = make(map[string]uint64, len(s.stats.Hits))
+	for domain, hits := range s.stats.Hits {
+		stats.Hits[domain] = hits
+	}
+	stats.BlocklistEntries = s.blocklist.Len()
+	stats.UpdatedAt = time.Now()
+	return stats
+}
+
+func (s *Server) WriteStats(path string) error {
+	data, err := json.MarshalIndent(s.Stats(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal DNS stats: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write DNS stats: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func LoadStats(path string) (*Stats, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS stats: %w", err)
+	}
+
+	var stats Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse DNS stats: %w", err)
+	}
+	return &stats, nil
+}
+
+func (st *Stats) TopHits(limit int) []DomainHits {
+	hits := make([]DomainHits, 0, len(st.Hits))
+	for domain, count := range st.Hits {
+		hits = append(hits, DomainHits{Domain: domain, Hits: count})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Hits != hits[j].Hits {
+			return hits[i].Hits > hits[j].Hits
+		}
+		return hits[i].Domain < hits[j].Domain
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
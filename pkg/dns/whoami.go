@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// WhoamiServer is a minimal authoritative server for a leak test zone. Every
+// name under the zone is answered with the address of the resolver that
+// asked, so a local instance can stand in for a public leak test service.
+type WhoamiServer struct {
+	listen string
+	zone   string
+
+	mu   sync.Mutex
+	conn net.PacketConn
+}
+
+func NewWhoamiServer(listen, zone string) *WhoamiServer {
+	return &WhoamiServer{
+		listen: listen,
+		zone:   strings.ToLower(strings.Trim(zone, ".")),
+	}
+}
+
+func (s *WhoamiServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.listen, err)
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	log.Printf("Leak test zone %s served on %s", s.zone, s.listen)
+
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read query: %w", err)
+		}
+
+		if reply := s.answer(buf[:n], addr); reply != nil {
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (s *WhoamiServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *WhoamiServer) answer(query []byte, addr net.Addr) []byte {
+	msg, err := ParseMessage(query)
+	if err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	q := msg.Questions[0]
+
+	if q.Name != s.zone && !strings.HasSuffix(q.Name, "."+s.zone) {
+		reply, _ := ErrorResponse(query, q, RcodeRefused)
+		return reply
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	ip := udpAddr.IP
+
+	var rdata [][]byte
+	switch q.Type {
+	case TypeA:
+		if v4 := ip.To4(); v4 != nil {
+			rdata = append(rdata, v4)
+		}
+	case TypeAAAA:
+		if ip.To4() == nil {
+			rdata = append(rdata, ip.To16())
+		}
+	case TypeTXT:
+		rdata = append(rdata, TXTData(ip.String()))
+	}
+
+	reply, _ := AnswerResponse(query, q, rdata)
+	return reply
+}
@@ -0,0 +1,323 @@
+package doctor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"darp/pkg/network"
+	"darp/pkg/warp"
+)
+
+func DefaultChecks() []Check {
+	return []Check{
+		{Name: "wireguard-tools", Run: checkWireGuardTools},
+		{Name: "wireguard-module", Run: checkWireGuardModule},
+		{Name: "tun-device", Run: checkTUN},
+		{Name: "privileges", Run: checkPrivileges},
+		{Name: "config", Run: checkConfig},
+		{Name: "resolver", Run: checkResolver},
+		{Name: "routes", Run: checkRoutes},
+		{Name: "firewall", Run: checkFirewall},
+		{Name: "endpoint", Run: checkEndpoint},
+		{Name: "handshake", Run: checkHandshake},
+		{Name: "dns-leak", Run: checkDNSLeak},
+		{Name: "ipv6-leak", Run: checkIPv6Leak},
+	}
+}
+
+func checkWireGuardTools(d *Doctor) Finding {
+	if err := warp.CheckWireGuardInstallation(); err != nil {
+		return failure("WireGuard tools are not installed",
+			"darp drives the tunnel through wg and wg-quick.",
+			"sudo pacman -S wireguard-tools")
+	}
+	return ok("wg and wg-quick found")
+}
+
+func checkWireGuardModule(d *Doctor) Finding {
+	if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+		return ok("wireguard kernel module loaded")
+	}
+	if err := exec.Command("modinfo", "wireguard").Run(); err == nil {
+		return info("wireguard kernel module available but not loaded; wg-quick loads it on connect")
+	}
+	return failure("wireguard kernel module not available",
+		"The running kernel has no WireGuard support, so wg-quick cannot create the interface. This usually means the kernel was upgraded and the system has not been rebooted.",
+		"Reboot into the installed kernel, or run: sudo modprobe wireguard")
+}
+
+func checkTUN(d *Doctor) Finding {
+	info, err := os.Stat("/dev/net/tun")
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return warning("/dev/net/tun is missing",
+			"Userspace WireGuard implementations need the TUN device. Containers often do not expose it.",
+			"sudo modprobe tun, or start the container with --device /dev/net/tun")
+	}
+	return ok("/dev/net/tun available")
+}
+
+func checkPrivileges(d *Doctor) Finding {
+	if os.Geteuid() != 0 {
+		return warning("not running as root",
+			"connect, disconnect, optimize and several doctor checks need root; their results may be incomplete.",
+			"sudo darp doctor")
+	}
+	return ok("running as root")
+}
+
+func checkConfig(d *Doctor) Finding {
+	if err := d.config.Validate(); err != nil {
+		return failure("configuration is invalid: "+err.Error(),
+			"darp refuses to connect with an invalid configuration.",
+			"Fix the value with darp config set, or edit ~/.config/darp/config.json")
+	}
+	return ok("configuration is valid")
+}
+
+func checkResolver(d *Doctor) Finding {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return failure("cannot read /etc/resolv.conf",
+			"Without a resolver configuration no names can be resolved.",
+			"Restore /etc/resolv.conf or enable systemd-resolved: sudo systemctl enable --now systemd-resolved")
+	}
+
+	stubResolver := strings.Contains(string(data), "nameserver 127.0.0.53")
+	if _, err := exec.LookPath("resolvconf"); err != nil && len(d.config.Network.DNS) > 0 {
+		return failure("resolvconf is not installed",
+			"wg-quick applies the DNS setting through resolvconf and fails to bring the tunnel up without it.",
+			"sudo pacman -S openresolv, or use systemd-resolved's resolvconf shim: sudo pacman -S systemd-resolvconf")
+	}
+
+	if stubResolver {
+		return ok("systemd-resolved stub resolver in use")
+	}
+	return ok("resolv.conf managed by resolvconf")
+}
+
+func checkRoutes(d *Doctor) Finding {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return warning("cannot read the routing table", err.Error(), "")
+	}
+	defer file.Close()
+
+	var conflicts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[0] == d.config.Network.Interface {
+			continue
+		}
+		if fields[1] == "00000000" && fields[7] == "00000000" && isTunnelDevice(fields[0]) {
+			conflicts = append(conflicts, fields[0])
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return warning("another VPN owns a default route via "+strings.Join(conflicts, ", "),
+			"Two tunnels competing for the default route leave traffic on whichever wins, usually not WARP.",
+			"Disconnect the other VPN first, e.g. nmcli connection down <name> or wg-quick down <iface>")
+	}
+	return ok("no conflicting default routes")
+}
+
+func isTunnelDevice(name string) bool {
+	for _, prefix := range []string{"tun", "tap", "wg", "ppp", "utun", "nordlynx", "proton"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFirewall(d *Doctor) Finding {
+	report, err := d.network.CheckFirewall(d.config.Cloudflare.WarpEndpoint)
+	if err != nil {
+		return warning("could not inspect firewall: "+err.Error(), "", "")
+	}
+
+	if blocked := report.Blocked(); len(blocked) > 0 {
+		check := blocked[0]
+		return failure(fmt.Sprintf("%s blocks %s (%s)", check.Backend, check.Traffic, check.Rule),
+			"WireGuard needs outbound UDP to the WARP endpoint and unrestricted traffic on the tunnel interface.",
+			firewallFix(check.Backend, d.config.Cloudflare.WarpEndpoint, d.config.Network.Interface))
+	}
+
+	for _, check := range report.Checks {
+		if check.Verdict == network.VerdictUncertain {
+			return warning(fmt.Sprintf("%s may affect %s (%s)", check.Backend, check.Traffic, check.Rule),
+				"The rule uses conditions darp cannot evaluate, so it may drop tunnel traffic.",
+				"Review the rule, or run darp test firewall for the full report")
+		}
+	}
+
+	if len(report.Backends) == 0 {
+		return info("no firewall tooling found")
+	}
+	return ok("tunnel traffic allowed by " + strings.Join(report.Backends, ", "))
+}
+
+func firewallFix(backend, endpoint, iface string) string {
+	host, port, _ := net.SplitHostPort(endpoint)
+	switch backend {
+	case "firewalld":
+		return "sudo firewall-cmd --zone=trusted --add-interface=" + iface
+	case "iptables-legacy":
+		return fmt.Sprintf("sudo iptables -I OUTPUT -p udp -d %s --dport %s -j ACCEPT && sudo iptables -I INPUT -i %s -j ACCEPT", host, port, iface)
+	}
+	return fmt.Sprintf("sudo nft insert rule inet filter output udp dport %s accept, and allow iifname/oifname %s", port, iface)
+}
+
+func checkEndpoint(d *Doctor) Finding {
+	endpoint := d.config.Cloudflare.WarpEndpoint
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		return failure("cannot resolve WARP endpoint "+host,
+			"The tunnel cannot start when the endpoint name does not resolve.",
+			"Check DNS with darp test dns, or set cloudflare.warp_endpoint to an IP address such as 162.159.192.1:2408")
+	}
+
+	result := d.network.CheckTarget(network.Target{Name: "WARP endpoint", Host: addrs[0], Protocol: "icmp"})
+	if !result.Passed {
+		return warning(fmt.Sprintf("WARP endpoint %s does not answer ping: %s", addrs[0], result.Detail),
+			"WireGuard uses UDP, so a filtered ping is not fatal, but it often means the path to Cloudflare is blocked.",
+			"darp test latency -t "+addrs[0]+" and check upstream firewalls")
+	}
+
+	return ok(fmt.Sprintf("%s (%s) reachable in %s", host, addrs[0], result.Detail))
+}
+
+func checkHandshake(d *Doctor) Finding {
+	iface := d.config.Network.Interface
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return info("tunnel is not up, handshake check skipped")
+	}
+
+	output, err := exec.Command("wg", "show", iface, "latest-handshakes").Output()
+	if err != nil {
+		return warning("cannot read handshake state of "+iface,
+			"wg show needs root to read the interface state.",
+			"sudo darp doctor")
+	}
+
+	var latest time.Time
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if sec, err := strconv.ParseInt(fields[1], 10, 64); err == nil && sec > 0 {
+			if t := time.Unix(sec, 0); t.After(latest) {
+				latest = t
+			}
+		}
+	}
+
+	if latest.IsZero() {
+		return failure("no handshake with the WARP endpoint",
+			"The interface is up but the peer never answered, so no traffic can flow. UDP to the endpoint is likely blocked.",
+			"Check the firewall and try another endpoint port, e.g. darp config set cloudflare.warp_endpoint engage.cloudflareclient.com:500")
+	}
+
+	age := time.Since(latest).Round(time.Second)
+	if age > 3*time.Minute {
+		return failure(fmt.Sprintf("last handshake was %s ago", age),
+			"WireGuard re-handshakes every two minutes while traffic flows; an older handshake means the tunnel is stale.",
+			"sudo darp disconnect && sudo darp connect")
+	}
+	return ok(fmt.Sprintf("last handshake %s ago", age))
+}
+
+func checkDNSLeak(d *Doctor) Finding {
+	if _, err := net.InterfaceByName(d.config.Network.Interface); err != nil {
+		return info("tunnel is not up, DNS leak check skipped")
+	}
+
+	leak := d.config.LeakTest
+	report, err := d.network.TestDNSLeak(leak.Zone, leak.Probes, leak.ExpectedResolvers, leak.Resolver)
+	if err != nil {
+		return warning("DNS leak test inconclusive: "+err.Error(),
+			"The leak test zone did not answer, so the resolver path could not be determined.",
+			"darp test leak --zone <zone>")
+	}
+
+	if report.Leaked() {
+		var leaked []string
+		for _, resolver := range report.Resolvers {
+			if !resolver.Expected {
+				leaked = append(leaked, resolver.Address)
+			}
+		}
+		return failure("DNS queries leak to "+strings.Join(leaked, ", "),
+			"Queries reach resolvers outside the tunnel, revealing visited names to the local network.",
+			"Make sure resolvconf applies the tunnel DNS (sudo pacman -S openresolv) and restart the tunnel")
+	}
+	return ok("no DNS leaks detected")
+}
+
+// checkIPv6Leak looks for native IPv6 that can bypass a tunnel without IPv6.
+func checkIPv6Leak(d *Doctor) Finding {
+	cfg := d.config.Network
+	tunnel, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return info("tunnel is not up, IPv6 leak check skipped")
+	}
+	if hasGlobalIPv6(tunnel) {
+		return ok("IPv6 is tunneled")
+	}
+	if !nativeIPv6() {
+		return ok("no native IPv6 connectivity to leak")
+	}
+	if cfg.IPv6 == "disable" {
+		return info("IPv6 bypasses the tunnel by configuration (network.ipv6 is disable)")
+	}
+	if cfg.Routing.Mode != "full" {
+		return info("IPv6 outside the tunnel is expected in " + cfg.Routing.Mode + " routing mode")
+	}
+
+	if method := network.NewIPv6Guard(cfg.Interface, cfg.IPv6Guard).Active(); method != "" {
+		return ok("native IPv6 blocked while connected (" + method + ")")
+	}
+	return failure("native IPv6 bypasses the tunnel",
+		"The tunnel has no IPv6 address but the physical network has an IPv6 default route, so IPv6 traffic leaves unencrypted and reveals your real address.",
+		"Set network.ipv6_guard to auto and reconnect: sudo darp disconnect && sudo darp connect")
+}
+
+func hasGlobalIPv6(iface *net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// nativeIPv6 reports whether the main table has an IPv6 default route.
+func nativeIPv6() bool {
+	routes, err := network.ListRoutes()
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Table == network.RouteTableMain && route.Family == "inet6" && route.IsDefault() && route.Type == "unicast" {
+			return true
+		}
+	}
+	return false
+}
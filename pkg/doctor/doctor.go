@@ -0,0 +1,93 @@
+package doctor
+
+import (
+	"time"
+
+	"darp/pkg/config"
+	"darp/pkg/network"
+)
+
+type Severity string
+
+const (
+	SeverityOK      Severity = "ok"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Finding is the outcome of one check. Explanation and Fix are only set when
+// something needs attention.
+type Finding struct {
+	Check       string   `json:"check"`
+	Severity    Severity `json:"severity"`
+	Summary     string   `json:"summary"`
+	Explanation string   `json:"explanation,omitempty"`
+	Fix         string   `json:"fix,omitempty"`
+}
+
+type Check struct {
+	Name string
+	Run  func(d *Doctor) Finding
+}
+
+type Report struct {
+	Findings  []Finding `json:"findings"`
+	Errors    int       `json:"errors"`
+	Warnings  int       `json:"warnings"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+}
+
+type Doctor struct {
+	config  *config.Config
+	network *network.Manager
+	checks  []Check
+}
+
+func New(cfg *config.Config) *Doctor {
+	netManager := network.NewManager(cfg.Network.Interface, cfg.Network.DNS)
+	return &Doctor{
+		config:  cfg,
+		network: netManager,
+		checks:  DefaultChecks(),
+	}
+}
+
+// Run executes every check in order. Checks never abort the pipeline, so
+// one broken component does not hide the state of the others.
+func (d *Doctor) Run() *Report {
+	report := &Report{StartedAt: time.Now()}
+
+	for _, check := range d.checks {
+		finding := check.Run(d)
+		finding.Check = check.Name
+
+		switch finding.Severity {
+		case SeverityError:
+			report.Errors++
+		case SeverityWarning:
+			report.Warnings++
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	return report
+}
+
+func ok(summary string) Finding {
+	return Finding{Severity: SeverityOK, Summary: summary}
+}
+
+func info(summary string) Finding {
+	return Finding{Severity: SeverityInfo, Summary: summary}
+}
+
+func warning(summary, explanation, fix string) Finding {
+	return Finding{Severity: SeverityWarning, Summary: summary, Explanation: explanation, Fix: fix}
+}
+
+func failure(summary, explanation, fix string) Finding {
+	return Finding{Severity: SeverityError, Summary: summary, Explanation: explanation, Fix: fix}
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Messages logged through the standard logger get their level from these
+// prefixes; anything else is info.
+var prefixLevels = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"Debug:", slog.LevelDebug},
+	{"Warning:", slog.LevelWarn},
+	{"Error:", slog.LevelError},
+}
+
+// Setup sends the standard logger through a slog handler. level is debug,
+// info, warn or error, format is json or text and output is stdout, stderr
+// or the path of a file to append to.
+func Setup(level, format, output string) error {
+	var w io.Writer
+	switch output {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = file
+	}
+
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: minLevel}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(&bridge{logger: slog.New(handler)})
+	return nil
+}
+
+// bridge turns lines from the standard logger into slog records.
+type bridge struct {
+	logger *slog.Logger
+}
+
+func (b *bridge) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	for _, pl := range prefixLevels {
+		if strings.HasPrefix(msg, pl.prefix) {
+			level = pl.level
+			msg = strings.TrimSpace(strings.TrimPrefix(msg, pl.prefix))
+			break
+		}
+	}
+	b.logger.Log(context.Background(), level, msg)
+	return len(p), nil
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setup(t *testing.T, level, format string) string {
+	t.Helper()
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	path := filepath.Join(t.TempDir(), "logs", "darp.log")
+	if err := Setup(level, format, path); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	return path
+}
+
+func records(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("log file: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestSetupJSON(t *testing.T) {
+	path := setup(t, "warn", "json")
+
+	log.Printf("Debug: ignored")
+	log.Printf("Connected")
+	log.Printf("Warning: failed to refresh tunnel: %s", "timeout")
+	log.Printf("Error: gave up")
+
+	lines := records(t, path)
+	want := []struct{ level, msg string }{
+		{"WARN", "failed to refresh tunnel: timeout"},
+		{"ERROR", "gave up"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d records, want %d:\n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	for i, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("record %q is not JSON: %v", line, err)
+		}
+		if record["level"] != want[i].level || record["msg"] != want[i].msg {
+			t.Errorf("record %d = %v/%v, want %s/%s", i, record["level"], record["msg"], want[i].level, want[i].msg)
+		}
+	}
+}
+
+func TestSetupText(t *testing.T) {
+	path := setup(t, "debug", "text")
+
+	log.Printf("Debug: probing %s", "eth0")
+	log.Printf("Connected")
+
+	lines := records(t, path)
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if !strings.Contains(lines[0], "level=DEBUG") || !strings.Contains(lines[0], `msg="probing eth0"`) {
+		t.Errorf("record %q is not a text debug record", lines[0])
+	}
+	if !strings.Contains(lines[1], "level=INFO") || !strings.Contains(lines[1], "msg=Connected") {
+		t.Errorf("record %q is not a text info record", lines[1])
+	}
+}
+
+func TestSetupAppends(t *testing.T) {
+	path := setup(t, "info", "text")
+	log.Printf("first")
+	if err := Setup("info", "text", path); err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("second")
+
+	if lines := records(t, path); len(lines) != 2 {
+		t.Errorf("got %d records, want 2: the file must be appended to", len(lines))
+	}
+}
+
+func TestSetupRejectsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := Setup("loud", "text", filepath.Join(dir, "a.log")); err == nil {
+		t.Error("invalid level accepted")
+	}
+	if err := Setup("info", "xml", filepath.Join(dir, "b.log")); err == nil {
+		t.Error("invalid format accepted")
+	}
+}
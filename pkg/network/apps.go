@@ -0,0 +1,138 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupRoot   = "/sys/fs/cgroup"
+	appsNftTable = "darp_apps"
+)
+
+// AppTunnel marks the traffic of selected processes so policy routing sends
+// only them through the tunnel. Processes join the darp cgroup, either
+// through darp run or by being part of a listed systemd unit, and an
+// nftables route chain sets Mark on every packet their sockets send.
+type AppTunnel struct {
+	Interface string
+	Cgroup    string
+	Mark      uint32
+	Units     []string
+}
+
+func NewAppTunnel(iface, cgroup string, mark uint32, units []string) *AppTunnel {
+	return &AppTunnel{
+		Interface: iface,
+		Cgroup:    cgroup,
+		Mark:      mark,
+		Units:     units,
+	}
+}
+
+// Apply creates the cgroup and (re)loads the marking rules. Units that are
+// not running have no cgroup yet and are returned as skipped; apply again
+// after starting them.
+func (a *AppTunnel) Apply() ([]string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroup v2 is not mounted at %s", cgroupRoot)
+	}
+	if err := os.MkdirAll(filepath.Join(cgroupRoot, a.Cgroup), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", a.Cgroup, err)
+	}
+
+	cgroups := []string{a.Cgroup}
+	var skipped []string
+	for _, unit := range a.Units {
+		cgroup, err := unitCgroup(unit)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", unit, err))
+			continue
+		}
+		cgroups = append(cgroups, cgroup)
+	}
+
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "table inet %s {\n", appsNftTable)
+	fmt.Fprintf(&script, "\tchain output {\n\t\ttype route hook output priority mangle; policy accept;\n")
+	for _, cgroup := range cgroups {
+		fmt.Fprintf(&script, "\t\tsocket cgroupv2 level %d %q meta mark set %d\n", strings.Count(cgroup, "/")+1, cgroup, a.Mark)
+	}
+	fmt.Fprintf(&script, "\t}\n")
+	// Sockets picked their source address for the physical link before the
+	// mark rerouted them.
+	fmt.Fprintf(&script, "\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	fmt.Fprintf(&script, "\t\toifname %q meta mark %d masquerade\n\t}\n}\n", a.Interface, a.Mark)
+
+	a.removeTable()
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = &script
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return skipped, fmt.Errorf("failed to load nftables rules: %s", strings.TrimSpace(string(output)))
+	}
+	return skipped, nil
+}
+
+// Remove deletes the marking rules and the cgroup. The cgroup can only be
+// removed once no process is left in it.
+func (a *AppTunnel) Remove() error {
+	a.removeTable()
+	if err := os.Remove(filepath.Join(cgroupRoot, a.Cgroup)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cgroup %s (processes still running?): %w", a.Cgroup, err)
+	}
+	return nil
+}
+
+func (a *AppTunnel) removeTable() {
+	exec.Command("nft", "delete", "table", "inet", appsNftTable).Run()
+}
+
+// Join moves the process into the darp cgroup. Children started afterwards
+// inherit it.
+func (a *AppTunnel) Join(pid int) error {
+	procs := filepath.Join(cgroupRoot, a.Cgroup, "cgroup.procs")
+	if err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("failed to move process %d into cgroup %s: %w", pid, a.Cgroup, err)
+	}
+	return nil
+}
+
+// Processes lists the PIDs currently in the darp cgroup.
+func (a *AppTunnel) Processes() ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(cgroupRoot, a.Cgroup, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Active reports whether the marking rules are loaded.
+func (a *AppTunnel) Active() bool {
+	return exec.Command("nft", "list", "table", "inet", appsNftTable).Run() == nil
+}
+
+func unitCgroup(unit string) (string, error) {
+	output, err := exec.Command("systemctl", "show", "--property=ControlGroup", "--value", unit).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query unit: %w", err)
+	}
+	cgroup := strings.Trim(strings.TrimSpace(string(output)), "/")
+	if cgroup == "" {
+		return "", fmt.Errorf("not running")
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, cgroup)); err != nil {
+		return "", fmt.Errorf("cgroup %s not found", cgroup)
+	}
+	return cgroup, nil
+}
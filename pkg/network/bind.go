@@ -0,0 +1,171 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"darp/pkg/dns"
+)
+
+// Binding pins test sockets to a device (SO_BINDTODEVICE) and/or a source
+// address, so a test can take the tunnel or the physical path regardless of
+// the default route.
+type Binding struct {
+	Device string `json:"device,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+func (b Binding) IsZero() bool {
+	return b.Device == "" && b.Source == ""
+}
+
+func (b Binding) String() string {
+	switch {
+	case b.Device != "" && b.Source != "":
+		return b.Device + " (" + b.Source + ")"
+	case b.Device != "":
+		return b.Device
+	case b.Source != "":
+		return b.Source
+	}
+	return "default route"
+}
+
+// WithBinding returns a copy of the manager whose tests use the binding.
+func (m *Manager) WithBinding(b Binding) (*Manager, error) {
+	if b.Source != "" && net.ParseIP(b.Source) == nil {
+		return nil, fmt.Errorf("invalid source address %q", b.Source)
+	}
+	if b.Device != "" {
+		if _, err := net.InterfaceByName(b.Device); err != nil {
+			return nil, fmt.Errorf("interface %s not found", b.Device)
+		}
+	}
+
+	bound := *m
+	bound.binding = b
+	bound.dnsClient = &dns.Client{
+		Timeout: m.dnsClient.Timeout,
+		Dial:    bound.dial,
+	}
+	return &bound, nil
+}
+
+func (m *Manager) Binding() Binding {
+	return m.binding
+}
+
+func (m *Manager) dialer(network string, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+
+	if m.binding.Source != "" {
+		ip := net.ParseIP(m.binding.Source)
+		switch {
+		case strings.HasPrefix(network, "udp"):
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		case strings.HasPrefix(network, "tcp"):
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		case strings.HasPrefix(network, "ip"):
+			d.LocalAddr = &net.IPAddr{IP: ip}
+		}
+	}
+
+	if device := m.binding.Device; device != "" {
+		d.Control = func(_, _ string, c syscall.RawConn) error {
+			return bindToDevice(c, device)
+		}
+	}
+
+	return d
+}
+
+func (m *Manager) dial(network, address string) (net.Conn, error) {
+	return m.dialer(network, 5*time.Second).Dial(network, address)
+}
+
+// systemResolver returns the system resolver, with its queries sent over the
+// binding when there is one.
+func (m *Manager) systemResolver() *net.Resolver {
+	if m.binding.IsZero() {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return m.dialer(network, 5*time.Second).DialContext(ctx, network, address)
+		},
+	}
+}
+
+func (m *Manager) httpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		network = m.familyNetwork(network)
+		return m.dialer(network, timeout).DialContext(ctx, network, address)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func bindToDevice(c syscall.RawConn, device string) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to bind to %s: %w", device, sockErr)
+	}
+	return nil
+}
+
+// PhysicalPath returns a copy of the manager bound to the interface of the
+// IPv4 default route, bypassing the tunnel.
+func (m *Manager) PhysicalPath() (*Manager, error) {
+	device, err := DefaultRouteInterface()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect physical interface: %w", err)
+	}
+	return m.WithBinding(Binding{Device: device})
+}
+
+// DefaultRouteInterface returns the device carrying the IPv4 default route.
+func DefaultRouteInterface() (string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", fmt.Errorf("failed to read routing table: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 8 && fields[1] == "00000000" && fields[7] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no default route found")
+}
+
+// HasDefaultRoute reports whether any routing table has a default route of
+// the family ("inet" or "inet6").
+func HasDefaultRoute(family string) bool {
+	routes, err := ListRoutes()
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Family == family && route.IsDefault() && route.Type == "unicast" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"darp/pkg/dns"
+)
+
+var DefaultTestDomains = []string{
+	"cloudflare.com",
+	"google.com",
+	"github.com",
+	"archlinux.org",
+}
+
+type DNSQueryResult struct {
+	Server  string        `json:"server"`
+	Domain  string        `json:"domain"`
+	Type    string        `json:"type"`
+	Rcode   string        `json:"rcode,omitempty"`
+	Answers []string      `json:"answers,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func (r DNSQueryResult) OK() bool {
+	return r.Error == "" && r.Rcode == "NOERROR"
+}
+
+func (r DNSQueryResult) Problem() string {
+	switch {
+	case r.Error != "":
+		return r.Error
+	case r.Rcode != "NOERROR":
+		return "answered " + r.Rcode
+	case len(r.Answers) == 0:
+		return "no " + r.Type + " records"
+	}
+	return ""
+}
+
+type DNSComparison struct {
+	Domain     string   `json:"domain"`
+	Type       string   `json:"type"`
+	System     []string `json:"system"`
+	Configured []string `json:"configured"`
+	Match      bool     `json:"match"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type DNSReport struct {
+	Results          []DNSQueryResult `json:"results"`
+	Comparisons      []DNSComparison  `json:"comparisons,omitempty"`
+	SystemResolvers  []string         `json:"system_resolvers,omitempty"`
+	UnknownResolvers []string         `json:"unknown_resolvers,omitempty"`
+}
+
+func (r *DNSReport) Failures() int {
+	failures := 0
+	for _, result := range r.Results {
+		if !result.OK() {
+			failures++
+		}
+	}
+	return failures
+}
+
+// TestDNS queries every configured server directly for the A and AAAA
+// records of each domain. With compareSystem set the answers are checked
+// against the system resolver, which flags a resolver stack that bypasses
+// the configured servers.
+func (m *Manager) TestDNS(domains []string, compareSystem bool) (*DNSReport, error) {
+	servers := m.servers()
+	if len(servers) == 0 {
+		if m.family != "" {
+			return nil, fmt.Errorf("no IPv%s DNS servers configured", m.family)
+		}
+		return nil, fmt.Errorf("no DNS servers configured")
+	}
+	if len(domains) == 0 {
+		domains = DefaultTestDomains
+	}
+
+	report := &DNSReport{}
+	configured := make(map[string][]string)
+
+	for _, server := range servers {
+		for _, domain := range domains {
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				result := m.queryServer(server, domain, qtype)
+				report.Results = append(report.Results, result)
+
+				key := domain + "/" + result.Type
+				configured[key] = append(configured[key], result.Answers...)
+			}
+		}
+	}
+
+	if !compareSystem {
+		return report, nil
+	}
+
+	for _, domain := range domains {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			key := domain + "/" + dns.TypeString(qtype)
+			report.Comparisons = append(report.Comparisons, compareSystemResolver(domain, qtype, configured[key]))
+		}
+	}
+
+	if info, err := m.getDNSInfo(); err == nil {
+		nameservers, _ := info["nameservers"].([]string)
+		report.SystemResolvers = nameservers
+		for _, ns := range nameservers {
+			if !m.isConfiguredServer(ns) {
+				report.UnknownResolvers = append(report.UnknownResolvers, ns)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (m *Manager) queryServer(server, domain string, qtype uint16) DNSQueryResult {
+	result := DNSQueryResult{
+		Server: server,
+		Domain: domain,
+		Type:   dns.TypeString(qtype),
+	}
+
+	msg, rtt, err := m.dnsClient.Query(server, domain, qtype)
+	result.Latency = rtt
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Rcode = dns.RcodeString(msg.Rcode())
+	for _, answer := range msg.Answers {
+		if answer.Type == qtype {
+			result.Answers = append(result.Answers, answer.Data)
+		}
+	}
+	return result
+}
+
+// servers returns the configured DNS servers of the selected family.
+func (m *Manager) servers() []string {
+	if m.family == "" {
+		return m.dnsServers
+	}
+	var servers []string
+	for _, server := range m.dnsServers {
+		if (Target{Host: serverHost(server)}).Family() == m.family {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+func serverHost(server string) string {
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		return host
+	}
+	return server
+}
+
+func (m *Manager) isConfiguredServer(addr string) bool {
+	for _, server := range m.dnsServers {
+		if serverHost(server) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func compareSystemResolver(domain string, qtype uint16, configured []string) DNSComparison {
+	comparison := DNSComparison{
+		Domain:     domain,
+		Type:       dns.TypeString(qtype),
+		Configured: uniqueSorted(configured),
+	}
+
+	network := "ip4"
+	if qtype == dns.TypeAAAA {
+		network = "ip6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, domain)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			comparison.Error = err.Error()
+			return comparison
+		}
+	}
+
+	var system []string
+	for _, ip := range ips {
+		system = append(system, ip.String())
+	}
+	comparison.System = uniqueSorted(system)
+	comparison.Match = overlaps(comparison.System, comparison.Configured) ||
+		(len(comparison.System) == 0 && len(comparison.Configured) == 0)
+
+	return comparison
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	var out []string
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// overlaps treats any shared address as agreement, since CDN-backed names
+// rotate through different subsets of the same pool.
+func overlaps(a, b []string) bool {
+	set := make(map[string]struct{}, len(a))
+	for _, v := range a {
+		set[strings.ToLower(v)] = struct{}{}
+	}
+	for _, v := range b {
+		if _, ok := set[strings.ToLower(v)]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type FirewallVerdict string
+
+const (
+	VerdictAllowed   FirewallVerdict = "allowed"
+	VerdictBlocked   FirewallVerdict = "blocked"
+	VerdictUncertain FirewallVerdict = "uncertain"
+)
+
+// FirewallCheck is the verdict of one backend for one kind of traffic. Rule
+// names the rule or chain policy that decided it.
+type FirewallCheck struct {
+	Traffic string          `json:"traffic"`
+	Backend string          `json:"backend"`
+	Verdict FirewallVerdict `json:"verdict"`
+	Rule    string          `json:"rule,omitempty"`
+}
+
+type FirewallReport struct {
+	Backends      []string        `json:"backends"`
+	FirewalldZone string          `json:"firewalld_zone,omitempty"`
+	Checks        []FirewallCheck `json:"checks"`
+	Notes         []string        `json:"notes,omitempty"`
+}
+
+func (r *FirewallReport) Blocked() []FirewallCheck {
+	var blocked []FirewallCheck
+	for _, check := range r.Checks {
+		if check.Verdict == VerdictBlocked {
+			blocked = append(blocked, check)
+		}
+	}
+	return blocked
+}
+
+// fwPacket describes the traffic a check asks about. Empty fields match any
+// rule condition on them.
+type fwPacket struct {
+	hook  string
+	proto string
+	daddr net.IP
+	dport int
+	iif   string
+	oif   string
+	state string
+}
+
+// CheckFirewall inspects nftables, iptables (nft and legacy) and firewalld
+// and reports whether WireGuard's UDP traffic to the endpoint and traffic on
+// the tunnel interface would be let through.
+func (m *Manager) CheckFirewall(endpoint string) (*FirewallReport, error) {
+	report := &FirewallReport{}
+
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	endpointIP := net.ParseIP(host)
+	if endpointIP == nil {
+		if addrs, err := net.LookupIP(host); err == nil && len(addrs) > 0 {
+			endpointIP = addrs[0]
+		} else {
+			report.Notes = append(report.Notes, "could not resolve "+host+"; endpoint address conditions are treated as uncertain")
+		}
+	}
+
+	physical, _ := DefaultRouteInterface()
+
+	traffic := []struct {
+		name   string
+		packet fwPacket
+	}{
+		{"udp to WARP endpoint", fwPacket{hook: "output", proto: "udp", daddr: endpointIP, dport: port, oif: physical, state: "new"}},
+		{"replies from WARP endpoint", fwPacket{hook: "input", proto: "udp", iif: physical, state: "established"}},
+		{"outbound on " + m.interfaceName, fwPacket{hook: "output", proto: "tcp", daddr: net.ParseIP("1.1.1.1"), dport: 443, oif: m.interfaceName, state: "new"}},
+		{"replies on " + m.interfaceName, fwPacket{hook: "input", proto: "tcp", iif: m.interfaceName, state: "established"}},
+	}
+
+	if ruleset, err := loadNftRuleset(); err == nil {
+		report.Backends = append(report.Backends, "nftables")
+		for _, t := range traffic {
+			verdict, rule := ruleset.evaluate(t.packet)
+			report.Checks = append(report.Checks, FirewallCheck{Traffic: t.name, Backend: "nftables", Verdict: verdict, Rule: rule})
+		}
+	}
+
+	// iptables-nft rules already live in the nftables ruleset, so only the
+	// legacy backend needs its own evaluation.
+	if variant, rules, err := loadIptables(); err == nil {
+		report.Backends = append(report.Backends, "iptables-"+variant)
+		if variant == "legacy" {
+			for _, t := range traffic {
+				verdict, rule := rules.evaluate(t.packet)
+				report.Checks = append(report.Checks, FirewallCheck{Traffic: t.name, Backend: "iptables-legacy", Verdict: verdict, Rule: rule})
+			}
+		}
+	}
+
+	if zone, target, err := firewalldZone(m.interfaceName); err == nil {
+		report.Backends = append(report.Backends, "firewalld")
+		report.FirewalldZone = zone
+		check := FirewallCheck{Traffic: "inbound on " + m.interfaceName, Backend: "firewalld", Verdict: VerdictAllowed, Rule: "zone " + zone + " target " + target}
+		if target == "DROP" || target == "%%REJECT%%" || target == "REJECT" {
+			check.Verdict = VerdictUncertain
+			report.Notes = append(report.Notes, fmt.Sprintf("%s is in firewalld zone %s which rejects unsolicited traffic; replies are still allowed", m.interfaceName, zone))
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	if len(report.Backends) == 0 {
+		report.Notes = append(report.Notes, "no firewall tooling found (nft, iptables, firewall-cmd); assuming traffic is allowed")
+	}
+
+	return report, nil
+}
+
+func firewalldZone(iface string) (string, string, error) {
+	if _, err := exec.LookPath("firewall-cmd"); err != nil {
+		return "", "", err
+	}
+	state, err := exec.Command("firewall-cmd", "--state").Output()
+	if err != nil || strings.TrimSpace(string(state)) != "running" {
+		return "", "", fmt.Errorf("firewalld not running")
+	}
+
+	output, err := exec.Command("firewall-cmd", "--get-zone-of-interface="+iface).Output()
+	zone := strings.TrimSpace(string(output))
+	if err != nil || zone == "" {
+		output, err = exec.Command("firewall-cmd", "--get-default-zone").Output()
+		if err != nil {
+			return "", "", err
+		}
+		zone = strings.TrimSpace(string(output))
+	}
+
+	target, err := exec.Command("firewall-cmd", "--permanent", "--zone="+zone, "--get-target").Output()
+	if err != nil {
+		return zone, "default", nil
+	}
+	return zone, strings.TrimSpace(string(target)), nil
+}
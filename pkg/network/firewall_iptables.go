@@ -0,0 +1,246 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type iptRules struct {
+	policies map[string]string
+	chains   map[string][][]string
+}
+
+// loadIptables reports which iptables backend is installed and parses the
+// filter table from iptables-save.
+func loadIptables() (string, *iptRules, error) {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return "", nil, err
+	}
+	version, err := exec.Command("iptables", "-V").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query iptables version: %w", err)
+	}
+	variant := "legacy"
+	if strings.Contains(string(version), "nf_tables") {
+		variant = "nft"
+	}
+
+	output, err := exec.Command("iptables-save", "-t", "filter").Output()
+	if err != nil {
+		return variant, nil, fmt.Errorf("failed to dump iptables rules: %w", err)
+	}
+	return variant, parseIptablesSave(output), nil
+}
+
+func parseIptablesSave(data []byte) *iptRules {
+	rules := &iptRules{policies: make(map[string]string), chains: make(map[string][][]string)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(line[1:])
+			if len(fields) >= 2 {
+				rules.policies[fields[0]] = fields[1]
+			}
+		case strings.HasPrefix(line, "-A "):
+			args := splitArgs(line[3:])
+			if len(args) > 0 {
+				rules.chains[args[0]] = append(rules.chains[args[0]], args[1:])
+			}
+		}
+	}
+	return rules
+}
+
+func splitArgs(line string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, inArg := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case r == ' ' && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+func (r *iptRules) evaluate(pkt fwPacket) (FirewallVerdict, string) {
+	chain := strings.ToUpper(pkt.hook)
+
+	result, rule := r.evalChain(chain, pkt, 0)
+	if result == "" {
+		result, rule = r.policies[chain], chain+" policy "+r.policies[chain]
+	}
+
+	switch result {
+	case "DROP", "REJECT":
+		return VerdictBlocked, rule
+	case "uncertain":
+		return VerdictUncertain, rule
+	}
+	return VerdictAllowed, rule
+}
+
+func (r *iptRules) evalChain(chain string, pkt fwPacket, depth int) (string, string) {
+	if depth > 16 {
+		return "uncertain", "chain nesting too deep at " + chain
+	}
+
+	uncertain := ""
+	for i, args := range r.chains[chain] {
+		desc := fmt.Sprintf("-A %s %s", chain, strings.Join(args, " "))
+		matched, known, target := iptMatch(args, pkt)
+		if target == "" || (known && !matched) {
+			continue
+		}
+		if !known {
+			if (target == "DROP" || target == "REJECT") && uncertain == "" {
+				uncertain = fmt.Sprintf("%s (rule %d)", desc, i+1)
+			}
+			continue
+		}
+
+		switch target {
+		case "ACCEPT":
+			if uncertain != "" {
+				return "uncertain", uncertain
+			}
+			return target, desc
+		case "DROP", "REJECT":
+			return target, desc
+		case "RETURN":
+			return "", ""
+		default:
+			if _, ok := r.chains[target]; !ok {
+				continue
+			}
+			if result, subRule := r.evalChain(target, pkt, depth+1); result != "" {
+				return result, subRule
+			}
+		}
+	}
+
+	if uncertain != "" && r.policies[chain] != "DROP" {
+		return "uncertain", uncertain
+	}
+	return "", ""
+}
+
+// iptMatch evaluates the match options of one rule and returns its jump
+// target. known is false when the rule uses options the inspector does not
+// understand.
+func iptMatch(args []string, pkt fwPacket) (matched, known bool, target string) {
+	matched, known = true, true
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		value := ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+
+		switch opt {
+		case "-j", "-g":
+			target = value
+			i++
+		case "-p":
+			matched = matched && (value == "all" || value == pkt.proto)
+			i++
+		case "-d":
+			i++
+			if pkt.daddr == nil {
+				known = false
+				continue
+			}
+			if !strings.Contains(value, "/") {
+				value += "/32"
+			}
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				known = false
+				continue
+			}
+			matched = matched && ipNet.Contains(pkt.daddr)
+		case "-o", "-i":
+			i++
+			iface := pkt.oif
+			if opt == "-i" {
+				iface = pkt.iif
+			}
+			if iface == "" {
+				known = false
+				continue
+			}
+			matched = matched && ifaceMatches(value, iface)
+		case "--dport":
+			i++
+			if pkt.dport == 0 {
+				known = false
+				continue
+			}
+			matched = matched && portMatches(value, pkt.dport)
+		case "--ctstate", "--state":
+			i++
+			if pkt.state == "" {
+				known = false
+				continue
+			}
+			found := false
+			for _, state := range strings.Split(value, ",") {
+				found = found || strings.EqualFold(state, pkt.state)
+			}
+			matched = matched && found
+		case "-m":
+			i++
+			switch value {
+			case "udp", "tcp", "conntrack", "state", "comment":
+			default:
+				known = false
+			}
+		case "--comment":
+			i++
+		default:
+			known = false
+		}
+	}
+	return matched, known, target
+}
+
+func ifaceMatches(pattern, iface string) bool {
+	if strings.HasSuffix(pattern, "+") {
+		return strings.HasPrefix(iface, strings.TrimSuffix(pattern, "+"))
+	}
+	return pattern == iface
+}
+
+func portMatches(spec string, port int) bool {
+	if low, high, ok := strings.Cut(spec, ":"); ok {
+		l, _ := strconv.Atoi(low)
+		h, err := strconv.Atoi(high)
+		if err != nil {
+			h = 65535
+		}
+		return port >= l && port <= h
+	}
+	p, _ := strconv.Atoi(spec)
+	return p == port
+}
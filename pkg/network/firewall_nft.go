@@ -0,0 +1,326 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+type nftChain struct {
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Hook   string `json:"hook"`
+	Prio   int    `json:"prio"`
+	Policy string `json:"policy"`
+	rules  []nftRule
+}
+
+type nftRule struct {
+	Family string                   `json:"family"`
+	Table  string                   `json:"table"`
+	Chain  string                   `json:"chain"`
+	Handle int                      `json:"handle"`
+	Expr   []map[string]interface{} `json:"expr"`
+}
+
+type nftRuleset struct {
+	chains map[string]*nftChain
+}
+
+func loadNftRuleset() (*nftRuleset, error) {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return nil, err
+	}
+	output, err := exec.Command("nft", "-j", "list", "ruleset").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nftables ruleset: %w", err)
+	}
+	return parseNftRuleset(output)
+}
+
+func parseNftRuleset(data []byte) (*nftRuleset, error) {
+	var doc struct {
+		Nftables []struct {
+			Chain *nftChain `json:"chain"`
+			Rule  *nftRule  `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse nftables ruleset: %w", err)
+	}
+
+	rs := &nftRuleset{chains: make(map[string]*nftChain)}
+	for _, obj := range doc.Nftables {
+		if obj.Chain != nil {
+			rs.chains[nftKey(obj.Chain.Family, obj.Chain.Table, obj.Chain.Name)] = obj.Chain
+		}
+	}
+	for _, obj := range doc.Nftables {
+		if obj.Rule == nil {
+			continue
+		}
+		if chain, ok := rs.chains[nftKey(obj.Rule.Family, obj.Rule.Table, obj.Rule.Chain)]; ok {
+			chain.rules = append(chain.rules, *obj.Rule)
+		}
+	}
+	return rs, nil
+}
+
+func nftKey(family, table, chain string) string {
+	return family + "/" + table + "/" + chain
+}
+
+// baseChains returns the filter base chains on hook that see traffic to
+// daddr, in the order the kernel runs them: by priority, lowest first.
+func (rs *nftRuleset) baseChains(hook string, daddr net.IP) []*nftChain {
+	var chains []*nftChain
+	for _, chain := range rs.chains {
+		if chain.Hook == hook && chain.Type == "filter" && familyApplies(chain.Family, daddr) {
+			chains = append(chains, chain)
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		if chains[i].Prio != chains[j].Prio {
+			return chains[i].Prio < chains[j].Prio
+		}
+		return nftKey(chains[i].Family, chains[i].Table, chains[i].Name) < nftKey(chains[j].Family, chains[j].Table, chains[j].Name)
+	})
+	return chains
+}
+
+// evaluate runs the packet through every filter base chain on its hook. A
+// drop anywhere wins; a rule that cannot be interpreted makes an otherwise
+// accepting result uncertain.
+func (rs *nftRuleset) evaluate(pkt fwPacket) (FirewallVerdict, string) {
+	verdict, decidedBy := VerdictAllowed, ""
+
+	for _, chain := range rs.baseChains(pkt.hook, pkt.daddr) {
+		result, rule := rs.evalChain(chain, pkt, 0)
+		if result == "" {
+			result, rule = chain.Policy, fmt.Sprintf("%s %s %s policy %s", chain.Family, chain.Table, chain.Name, chain.Policy)
+		}
+
+		switch result {
+		case "drop", "reject":
+			return VerdictBlocked, rule
+		case "uncertain":
+			verdict, decidedBy = VerdictUncertain, rule
+		default:
+			if verdict == VerdictAllowed {
+				decidedBy = rule
+			}
+		}
+	}
+
+	return verdict, decidedBy
+}
+
+func (rs *nftRuleset) evalChain(chain *nftChain, pkt fwPacket, depth int) (string, string) {
+	if depth > 16 {
+		return "uncertain", "chain nesting too deep at " + chain.Name
+	}
+
+	uncertain := ""
+	for _, rule := range chain.rules {
+		desc := fmt.Sprintf("%s %s %s handle %d", rule.Family, rule.Table, rule.Chain, rule.Handle)
+
+		matched, known := true, true
+		verdict, target := "", ""
+		for _, expr := range rule.Expr {
+			for key, value := range expr {
+				switch key {
+				case "match":
+					m, k := nftMatch(value, pkt)
+					matched = matched && m
+					known = known && k
+				case "accept", "drop", "return":
+					verdict = key
+				case "reject":
+					verdict = "drop"
+				case "jump", "goto":
+					verdict = key
+					if args, ok := value.(map[string]interface{}); ok {
+						target, _ = args["target"].(string)
+					}
+				case "xt":
+					known = false
+				}
+			}
+		}
+
+		if verdict == "" || (known && !matched) {
+			continue
+		}
+		if !known {
+			if verdict == "drop" && uncertain == "" {
+				uncertain = desc
+			}
+			continue
+		}
+
+		switch verdict {
+		case "accept":
+			if uncertain != "" {
+				return "uncertain", uncertain
+			}
+			return "accept", desc
+		case "drop":
+			return "drop", desc
+		case "return":
+			return "", ""
+		case "jump", "goto":
+			sub, ok := rs.chains[nftKey(chain.Family, chain.Table, target)]
+			if !ok {
+				continue
+			}
+			result, subRule := rs.evalChain(sub, pkt, depth+1)
+			if result != "" || verdict == "goto" {
+				return result, subRule
+			}
+		}
+	}
+
+	if uncertain != "" && chain.Policy != "drop" {
+		return "uncertain", uncertain
+	}
+	return "", ""
+}
+
+func familyApplies(family string, daddr net.IP) bool {
+	switch family {
+	case "inet":
+		return true
+	case "ip":
+		return daddr == nil || daddr.To4() != nil
+	case "ip6":
+		return daddr == nil || daddr.To4() == nil
+	}
+	return false
+}
+
+// nftMatch interprets one match expression. The second result is false when
+// the expression is outside what the inspector understands.
+func nftMatch(value interface{}, pkt fwPacket) (bool, bool) {
+	match, ok := value.(map[string]interface{})
+	if !ok {
+		return false, false
+	}
+	op, _ := match["op"].(string)
+	left, _ := match["left"].(map[string]interface{})
+	right := match["right"]
+
+	var matched, known bool
+	switch {
+	case left["meta"] != nil:
+		key, _ := left["meta"].(map[string]interface{})["key"].(string)
+		switch key {
+		case "l4proto":
+			matched, known = matchValue(right, pkt.proto, 0, nil)
+		case "oifname", "oif":
+			if pkt.oif == "" {
+				return false, false
+			}
+			matched, known = matchValue(right, pkt.oif, 0, nil)
+		case "iifname", "iif":
+			if pkt.iif == "" {
+				return false, false
+			}
+			matched, known = matchValue(right, pkt.iif, 0, nil)
+		case "nfproto":
+			family := "ipv6"
+			if pkt.daddr == nil || pkt.daddr.To4() != nil {
+				family = "ipv4"
+			}
+			matched, known = matchValue(right, family, 0, nil)
+		default:
+			return false, false
+		}
+
+	case left["payload"] != nil:
+		payload, _ := left["payload"].(map[string]interface{})
+		protocol, _ := payload["protocol"].(string)
+		field, _ := payload["field"].(string)
+		switch {
+		case (protocol == "ip" || protocol == "ip6") && field == "daddr":
+			if pkt.daddr == nil {
+				return false, false
+			}
+			matched, known = matchValue(right, "", 0, pkt.daddr)
+		case (protocol == "ip" && field == "protocol") || (protocol == "ip6" && field == "nexthdr"):
+			matched, known = matchValue(right, pkt.proto, 0, nil)
+		case (protocol == "udp" || protocol == "tcp" || protocol == "th") && field == "dport":
+			if protocol != "th" && protocol != pkt.proto {
+				matched, known = false, true
+				break
+			}
+			if pkt.dport == 0 {
+				return false, false
+			}
+			matched, known = matchValue(right, "", pkt.dport, nil)
+		default:
+			return false, false
+		}
+
+	case left["ct"] != nil:
+		key, _ := left["ct"].(map[string]interface{})["key"].(string)
+		if key != "state" || pkt.state == "" {
+			return false, false
+		}
+		matched, known = matchValue(right, pkt.state, 0, nil)
+
+	default:
+		return false, false
+	}
+
+	if op == "!=" {
+		matched = !matched
+	}
+	return matched, known
+}
+
+// matchValue compares an nft right-hand side against a string, port or
+// address.
+func matchValue(right interface{}, s string, n int, ip net.IP) (bool, bool) {
+	switch v := right.(type) {
+	case string:
+		if ip != nil {
+			return ip.Equal(net.ParseIP(v)), true
+		}
+		return strings.EqualFold(v, s) || (strings.HasSuffix(v, "*") && strings.HasPrefix(s, strings.TrimSuffix(v, "*"))), true
+	case float64:
+		return int(v) == n, true
+	case []interface{}:
+		for _, elem := range v {
+			if m, k := matchValue(elem, s, n, ip); !k {
+				return false, false
+			} else if m {
+				return true, true
+			}
+		}
+		return false, true
+	case map[string]interface{}:
+		if set, ok := v["set"]; ok {
+			return matchValue(set, s, n, ip)
+		}
+		if prefix, ok := v["prefix"].(map[string]interface{}); ok && ip != nil {
+			addr, _ := prefix["addr"].(string)
+			length, _ := prefix["len"].(float64)
+			_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, int(length)))
+			if err != nil {
+				return false, false
+			}
+			return ipNet.Contains(ip), true
+		}
+		if r, ok := v["range"].([]interface{}); ok && len(r) == 2 {
+			low, _ := r[0].(float64)
+			high, _ := r[1].(float64)
+			return n >= int(low) && n <= int(high), true
+		}
+	}
+	return false, false
+}
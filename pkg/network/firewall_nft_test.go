@@ -0,0 +1,179 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+const testRuleset = `{"nftables": [
+  {"metainfo": {"json_schema_version": 1}},
+  {"table": {"family": "inet", "name": "filter"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "output", "type": "filter", "hook": "output", "prio": 10, "policy": "accept"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "early", "type": "filter", "hook": "output", "prio": -10, "policy": "accept"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "blocked"}},
+  {"chain": {"family": "ip6", "table": "v6", "name": "output", "type": "filter", "hook": "output", "prio": 0, "policy": "drop"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "nat", "type": "nat", "hook": "output", "prio": -100, "policy": "drop"}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "output", "handle": 4,
+    "expr": [{"match": {"op": "==", "left": {"meta": {"key": "oifname"}}, "right": "warp0"}}, {"accept": null}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "output", "handle": 5,
+    "expr": [{"match": {"op": "==", "left": {"payload": {"protocol": "udp", "field": "dport"}}, "right": {"set": [500, 4500]}}}, {"jump": {"target": "blocked"}}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "output", "handle": 6,
+    "expr": [{"xt": {"type": "match", "name": "owner"}}, {"drop": null}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "blocked", "handle": 7,
+    "expr": [{"counter": {"packets": 0, "bytes": 0}}, {"drop": null}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "missing", "handle": 8,
+    "expr": [{"drop": null}]}}
+]}`
+
+func TestParseNftRuleset(t *testing.T) {
+	rs, err := parseNftRuleset([]byte(testRuleset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.chains) != 5 {
+		t.Fatalf("chains = %d, want 5", len(rs.chains))
+	}
+	output := rs.chains[nftKey("inet", "filter", "output")]
+	if output == nil || output.Prio != 10 || output.Policy != "accept" || len(output.rules) != 3 {
+		t.Fatalf("output chain = %+v", output)
+	}
+	if rules := rs.chains[nftKey("inet", "filter", "blocked")].rules; len(rules) != 1 || rules[0].Handle != 7 {
+		t.Errorf("blocked rules = %+v", rules)
+	}
+
+	if _, err := parseNftRuleset([]byte(`{"nftables": [`)); err == nil {
+		t.Error("truncated ruleset parsed without error")
+	}
+}
+
+func TestBaseChainsByPriority(t *testing.T) {
+	rs, err := parseNftRuleset([]byte(testRuleset))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		daddr net.IP
+		want  []string
+	}{
+		{"ipv4", net.ParseIP("192.0.2.1"), []string{"early", "output"}},
+		{"ipv6", net.ParseIP("2001:db8::1"), []string{"early", "output", "output"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chains := rs.baseChains("output", tt.daddr)
+			var got []string
+			for _, chain := range chains {
+				got = append(got, chain.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("chains = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("chains = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+	if chains := rs.baseChains("output", net.ParseIP("2001:db8::1")); chains[1].Family != "ip6" {
+		t.Errorf("prio 0 chain = %s %s, want the ip6 chain", chains[1].Family, chains[1].Table)
+	}
+}
+
+func TestEvalChain(t *testing.T) {
+	rs, err := parseNftRuleset([]byte(testRuleset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := rs.chains[nftKey("inet", "filter", "output")]
+
+	tests := []struct {
+		name       string
+		pkt        fwPacket
+		wantResult string
+		wantRule   string
+	}{
+		{"accepted on tunnel", fwPacket{hook: "output", proto: "udp", oif: "warp0"}, "accept", "inet filter output handle 4"},
+		{"jump to drop", fwPacket{hook: "output", proto: "udp", dport: 4500, oif: "eth0"}, "drop", "inet filter blocked handle 7"},
+		{"tcp skips udp set", fwPacket{hook: "output", proto: "tcp", dport: 4500, oif: "eth0"}, "uncertain", "inet filter output handle 6"},
+		{"unknown match before policy", fwPacket{hook: "output", proto: "udp", dport: 2408, oif: "eth0"}, "uncertain", "inet filter output handle 6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, rule := rs.evalChain(output, tt.pkt, 0)
+			if result != tt.wantResult || rule != tt.wantRule {
+				t.Errorf("evalChain = %q, %q; want %q, %q", result, rule, tt.wantResult, tt.wantRule)
+			}
+		})
+	}
+
+	if result, _ := rs.evalChain(output, fwPacket{hook: "output", proto: "udp", oif: "warp0"}, 17); result != "uncertain" {
+		t.Errorf("deep nesting = %q, want uncertain", result)
+	}
+}
+
+func TestEvaluateFollowsPriority(t *testing.T) {
+	rs, err := parseNftRuleset([]byte(testRuleset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := fwPacket{hook: "output", proto: "udp", daddr: net.ParseIP("192.0.2.1"), oif: "warp0"}
+
+	// Both chains accept; the one running last decides.
+	for i := 0; i < 20; i++ {
+		verdict, rule := rs.evaluate(pkt)
+		if verdict != VerdictAllowed || rule != "inet filter output handle 4" {
+			t.Fatalf("evaluate = %s, %q", verdict, rule)
+		}
+	}
+
+	pkt.daddr = net.ParseIP("2001:db8::1")
+	if verdict, rule := rs.evaluate(pkt); verdict != VerdictBlocked || rule != "ip6 v6 output policy drop" {
+		t.Errorf("evaluate ipv6 = %s, %q", verdict, rule)
+	}
+}
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		right     interface{}
+		s         string
+		n         int
+		ip        string
+		wantMatch bool
+		wantKnown bool
+	}{
+		{"string", "warp0", "warp0", 0, "", true, true},
+		{"string case", "UDP", "udp", 0, "", true, true},
+		{"string mismatch", "eth0", "warp0", 0, "", false, true},
+		{"wildcard", "wg*", "wg0", 0, "", true, true},
+		{"wildcard mismatch", "wg*", "warp0", 0, "", false, true},
+		{"port", float64(2408), "", 2408, "", true, true},
+		{"port mismatch", float64(53), "", 2408, "", false, true},
+		{"address", "192.0.2.1", "", 0, "192.0.2.1", true, true},
+		{"address mismatch", "192.0.2.2", "", 0, "192.0.2.1", false, true},
+		{"set", map[string]interface{}{"set": []interface{}{float64(500), float64(2408)}}, "", 2408, "", true, true},
+		{"set mismatch", []interface{}{float64(500), float64(4500)}, "", 2408, "", false, true},
+		{"set with unknown", []interface{}{float64(500), true}, "", 2408, "", false, false},
+		{"prefix", map[string]interface{}{"prefix": map[string]interface{}{"addr": "192.0.2.0", "len": float64(24)}}, "", 0, "192.0.2.9", true, true},
+		{"prefix mismatch", map[string]interface{}{"prefix": map[string]interface{}{"addr": "198.51.100.0", "len": float64(24)}}, "", 0, "192.0.2.9", false, true},
+		{"bad prefix", map[string]interface{}{"prefix": map[string]interface{}{"addr": "nope", "len": float64(24)}}, "", 0, "192.0.2.9", false, false},
+		{"range", map[string]interface{}{"range": []interface{}{float64(2400), float64(2500)}}, "", 2408, "", true, true},
+		{"range mismatch", map[string]interface{}{"range": []interface{}{float64(1), float64(1024)}}, "", 2408, "", false, true},
+		{"unknown", true, "udp", 0, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip net.IP
+			if tt.ip != "" {
+				ip = net.ParseIP(tt.ip)
+			}
+			matched, known := matchValue(tt.right, tt.s, tt.n, ip)
+			if matched != tt.wantMatch || known != tt.wantKnown {
+				t.Errorf("matchValue = %v, %v; want %v, %v", matched, known, tt.wantMatch, tt.wantKnown)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	ipv6GuardNftTable = "darp_ipv6"
+	globalIPv6        = "2000::/3"
+)
+
+// IPv6Guard blocks global unicast IPv6 on every interface except the tunnel
+// and loopback while a tunnel without IPv6 is up, so native IPv6 cannot
+// bypass it. Method is "nftables" (a reject rule in its own table), "route"
+// (an unreachable route for 2000::/3 in the main table) or "auto", which
+// prefers nftables and falls back to the route.
+type IPv6Guard struct {
+	Tunnel string
+	Method string
+}
+
+func NewIPv6Guard(tunnel, method string) *IPv6Guard {
+	return &IPv6Guard{Tunnel: tunnel, Method: method}
+}
+
+// Enable installs the block and returns the method used.
+func (g *IPv6Guard) Enable() (string, error) {
+	switch g.Method {
+	case "nftables":
+		return "nftables", g.enableNft()
+	case "route":
+		return "route", g.enableRoute()
+	}
+
+	if _, err := exec.LookPath("nft"); err == nil {
+		if err := g.enableNft(); err == nil {
+			return "nftables", nil
+		}
+	}
+	return "route", g.enableRoute()
+}
+
+func (g *IPv6Guard) enableNft() error {
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "table inet %s {\n", ipv6GuardNftTable)
+	for _, hook := range []string{"output", "forward"} {
+		fmt.Fprintf(&script, "\tchain %s {\n\t\ttype filter hook %s priority filter; policy accept;\n", hook, hook)
+		fmt.Fprintf(&script, "\t\toifname != { \"lo\", %q } ip6 daddr %s reject with icmpv6 type admin-prohibited\n\t}\n", g.Tunnel, globalIPv6)
+	}
+	fmt.Fprintf(&script, "}\n")
+
+	exec.Command("nft", "delete", "table", "inet", ipv6GuardNftTable).Run()
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = &script
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load nftables rules: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (g *IPv6Guard) enableRoute() error {
+	if err := ip("-6", "route", "replace", "unreachable", globalIPv6, "table", "main"); err != nil {
+		return fmt.Errorf("failed to add unreachable route for %s: %w", globalIPv6, err)
+	}
+	return nil
+}
+
+// Disable removes whichever block is installed. It is safe to call when
+// none is.
+func (g *IPv6Guard) Disable() error {
+	exec.Command("nft", "delete", "table", "inet", ipv6GuardNftTable).Run()
+	if g.routeInstalled() {
+		if err := ip("-6", "route", "del", "unreachable", globalIPv6, "table", "main"); err != nil {
+			return fmt.Errorf("failed to remove unreachable route for %s: %w", globalIPv6, err)
+		}
+	}
+	return nil
+}
+
+// Active returns the method of the installed block, or an empty string.
+func (g *IPv6Guard) Active() string {
+	if exec.Command("nft", "list", "table", "inet", ipv6GuardNftTable).Run() == nil {
+		return "nftables"
+	}
+	if g.routeInstalled() {
+		return "route"
+	}
+	return ""
+}
+
+func (g *IPv6Guard) routeInstalled() bool {
+	routes, err := ListRoutes()
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Table == RouteTableMain && route.Type == "unreachable" && route.Dst == globalIPv6 {
+			return true
+		}
+	}
+	return false
+}
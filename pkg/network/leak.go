@@ -0,0 +1,199 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"darp/pkg/dns"
+)
+
+type LeakResolver struct {
+	Address  string `json:"address"`
+	Queries  int    `json:"queries"`
+	Expected bool   `json:"expected"`
+}
+
+type LeakReport struct {
+	Zone      string         `json:"zone"`
+	Probes    int            `json:"probes"`
+	Answered  int            `json:"answered"`
+	Resolvers []LeakResolver `json:"resolvers"`
+	Errors    []string       `json:"errors,omitempty"`
+}
+
+func (r *LeakReport) Leaked() bool {
+	for _, resolver := range r.Resolvers {
+		if !resolver.Expected {
+			return true
+		}
+	}
+	return false
+}
+
+// TestDNSLeak resolves unique names under zone through the system resolver,
+// or through resolver when it is set. The zone's authoritative server
+// answers with the address of the resolver that asked, so every probe
+// reveals one resolver on the real query path.
+func (m *Manager) TestDNSLeak(zone string, probes int, expected []string, resolver string) (*LeakReport, error) {
+	zone = strings.Trim(zone, ".")
+	if zone == "" {
+		return nil, fmt.Errorf("no leak test zone configured")
+	}
+	if probes <= 0 {
+		probes = 1
+	}
+
+	var networks []*net.IPNet
+	for _, cidr := range expected {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected resolver network %q: %w", cidr, err)
+		}
+		networks = append(networks, ipNet)
+	}
+
+	nonce := make([]byte, 6)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate probe id: %w", err)
+	}
+
+	lookup := m.lookupResolverAddrs
+	if resolver != "" {
+		lookup = func(name string) ([]string, error) {
+			return m.queryResolverAddrs(resolver, name)
+		}
+		// A resolver that is the zone's own server, such as a local whoami
+		// instance, reports itself or the address darp queried it from.
+		for _, ip := range []net.IP{resolverIP(resolver), resolverIP(m.binding.Source)} {
+			if ip != nil {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			}
+		}
+	}
+
+	report := &LeakReport{Zone: zone, Probes: probes}
+	seen := make(map[string]int)
+
+	for i := 0; i < probes; i++ {
+		name := fmt.Sprintf("%s-%d.%s", hex.EncodeToString(nonce), i, zone)
+
+		addrs, err := lookup(name)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		if len(addrs) > 0 {
+			report.Answered++
+		}
+		for _, addr := range addrs {
+			seen[addr]++
+		}
+	}
+
+	for addr, count := range seen {
+		report.Resolvers = append(report.Resolvers, LeakResolver{
+			Address:  addr,
+			Queries:  count,
+			Expected: inNetworks(net.ParseIP(addr), networks),
+		})
+	}
+	sort.Slice(report.Resolvers, func(i, j int) bool {
+		return report.Resolvers[i].Address < report.Resolvers[j].Address
+	})
+
+	if report.Answered == 0 {
+		return report, fmt.Errorf("no leak test probes were answered by %s", zone)
+	}
+	return report, nil
+}
+
+// queryResolverAddrs asks resolver directly, which lets a local whoami
+// server stand in for the public zone.
+func (m *Manager) queryResolverAddrs(resolver, name string) ([]string, error) {
+	var addrs []string
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeA, dns.TypeAAAA} {
+		msg, _, err := m.dnsClient.Query(resolver, name, qtype)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s via %s: %w", name, resolver, err)
+		}
+		for _, answer := range msg.Answers {
+			if answer.Type != qtype {
+				continue
+			}
+			for _, field := range strings.Fields(answer.Data) {
+				if ip := net.ParseIP(field); ip != nil {
+					addrs = append(addrs, ip.String())
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+	return nil, fmt.Errorf("no answer for %s from %s", name, resolver)
+}
+
+func (m *Manager) lookupResolverAddrs(name string) ([]string, error) {
+	resolver := m.systemResolver()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records, err := resolver.LookupTXT(ctx, name)
+	if err == nil {
+		var addrs []string
+		for _, record := range records {
+			for _, field := range strings.Fields(record) {
+				// Go joins the strings of a TXT record, so "ns" "1.2.3.4"
+				// arrives as "ns1.2.3.4".
+				field = strings.TrimPrefix(field, "ns")
+				if ip := net.ParseIP(field); ip != nil {
+					addrs = append(addrs, ip.String())
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+
+	ips, err := resolver.LookupIP(ctx, "ip", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
+
+func resolverIP(resolver string) net.IP {
+	host := resolver
+	if h, _, err := net.SplitHostPort(resolver); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range networks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,17 +6,24 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"darp/pkg/dns"
 )
 
 type Manager struct {
 	interfaceName string
 	dnsServers    []string
+	dnsClient     *dns.Client
+	binding       Binding
+	targets       []Target
+	family        string
 }
 
 func NewManager(interfaceName string, dnsServers []string) *Manager {
 	return &Manager{
 		interfaceName: interfaceName,
 		dnsServers:    dnsServers,
+		dnsClient:     dns.NewClient(5 * time.Second),
 	}
 }
 
@@ -32,21 +39,79 @@ func (m *Manager) CheckConnectivity() error {
 	return nil
 }
 
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RunConnectivityTests runs every connectivity check and reports each
+// outcome instead of stopping at the first failure.
+func (m *Manager) RunConnectivityTests(traceURL string) []CheckResult {
+	var results []CheckResult
+
+	record := func(name string, err error, detail string) {
+		result := CheckResult{Name: name, Passed: err == nil, Detail: detail}
+		if err != nil {
+			result.Detail = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	record("DNS Resolution", m.testDNSResolution(), "")
+	for _, target := range m.Targets() {
+		results = append(results, m.CheckTarget(target))
+	}
+
+	var ifaceErr error
+	if _, err := net.InterfaceByName(m.interfaceName); err != nil {
+		ifaceErr = fmt.Errorf("interface %s not found", m.interfaceName)
+	}
+	record("WireGuard Interface", ifaceErr, m.interfaceName)
+
+	trace, err := m.VerifyTunnel(traceURL)
+	detail := ""
+	if trace != nil {
+		detail = fmt.Sprintf("warp=%s ip=%s colo=%s", trace.Warp, trace.IP, trace.Colo)
+	}
+	record("WARP Egress", err, detail)
+
+	return results
+}
+
 func (m *Manager) testDNSResolution() error {
-	_, err := net.LookupHost("cloudflare.com")
-	if err != nil {
-		return fmt.Errorf("DNS resolution failed: %w", err)
+	var lastErr error
+	for _, server := range m.servers() {
+		result := m.queryServer(server, "cloudflare.com", dns.TypeA)
+		if result.OK() && len(result.Answers) > 0 {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %s", server, result.Problem())
 	}
-	return nil
+	if lastErr == nil {
+		return fmt.Errorf("no DNS servers configured")
+	}
+	return fmt.Errorf("DNS resolution failed: %w", lastErr)
 }
 
+// testInternetConnectivity passes when any target expected to be reachable
+// answers.
 func (m *Manager) testInternetConnectivity() error {
-	conn, err := net.DialTimeout("tcp", "1.1.1.1:80", 5*time.Second)
-	if err != nil {
-		return err
+	var lastErr error
+	for _, target := range m.Targets() {
+		if !target.expectsReachable() {
+			continue
+		}
+		result := m.CheckTarget(target)
+		if result.Passed {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %s", target.Label(), result.Detail)
 	}
-	conn.Close()
-	return nil
+	if lastErr == nil {
+		return fmt.Errorf("no connectivity targets configured")
+	}
+	return lastErr
 }
 
 func (m *Manager) GetNetworkInfo() (map[string]interface{}, error) {
@@ -58,7 +123,7 @@ func (m *Manager) GetNetworkInfo() (map[string]interface{}, error) {
 	}
 	info["interface"] = ifaceInfo
 
-	routes, err := m.getRoutingInfo()
+	routes, err := ListRoutes()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get routing info: %w", err)
 	}
@@ -104,39 +169,6 @@ func (m *Manager) getInterfaceInfo() (map[string]interface{}, error) {
 	return info, nil
 }
 
-func (m *Manager) getRoutingInfo() ([]map[string]string, error) {
-	cmd := exec.Command("ip", "route", "show")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get routing info: %w", err)
-	}
-
-	var routes []map[string]string
-	lines := strings.Split(string(output), "\n")
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		route := make(map[string]string)
-		parts := strings.Fields(line)
-		
-		if len(parts) >= 3 {
-			route["destination"] = parts[0]
-			route["gateway"] = parts[2]
-			if len(parts) > 3 {
-				route["interface"] = parts[3]
-			}
-		}
-		
-		routes = append(routes, route)
-	}
-
-	return routes, nil
-}
-
 func (m *Manager) getDNSInfo() (map[string]interface{}, error) {
 	info := make(map[string]interface{})
 	
@@ -163,67 +195,15 @@ func (m *Manager) getDNSInfo() (map[string]interface{}, error) {
 }
 
 func (m *Manager) TestLatency() (map[string]time.Duration, error) {
-	endpoints := []string{
-		"1.1.1.1",
-		"1.0.0.1",
-		"8.8.8.8",
-		"8.8.4.4",
-	}
-
 	results := make(map[string]time.Duration)
 
-	for _, endpoint := range endpoints {
-		latency, err := m.pingEndpoint(endpoint)
-		if err != nil {
-			results[endpoint] = -1
+	for _, stats := range m.MeasureLatency(LatencyTargets(m.Targets()), DefaultProbeOptions()) {
+		if stats.Received == 0 {
+			results[stats.Target] = -1
 			continue
 		}
-		results[endpoint] = latency
+		results[stats.Target] = stats.Avg
 	}
 
 	return results, nil
 }
-
-func (m *Manager) pingEndpoint(endpoint string) (time.Duration, error) {
-	start := time.Now()
-	conn, err := net.DialTimeout("tcp", endpoint+":80", 5*time.Second)
-	if err != nil {
-		return 0, err
-	}
-	conn.Close()
-	return time.Since(start), nil
-}
-
-func (m *Manager) CheckFirewall() error {
-	cmd := exec.Command("systemctl", "is-active", "iptables")
-	output, err := cmd.Output()
-	if err == nil && strings.TrimSpace(string(output)) == "active" {
-		cmd = exec.Command("iptables", "-L", "INPUT", "-n")
-		output, err = cmd.Output()
-		if err != nil {
-			return fmt.Errorf("failed to check iptables rules: %w", err)
-		}
-
-		if strings.Contains(string(output), "DROP") || strings.Contains(string(output), "REJECT") {
-			return fmt.Errorf("firewall may be blocking connections")
-		}
-	}
-
-	return nil
-}
-
-func (m *Manager) OptimizeNetwork() error {
-	cmd := exec.Command("sysctl", "-w", "net.core.default_qdisc=fq")
-	cmd.Run()
-
-	cmd = exec.Command("sysctl", "-w", "net.ipv4.tcp_congestion_control=bbr")
-	cmd.Run()
-
-	cmd = exec.Command("sysctl", "-w", "net.core.rmem_max=134217728")
-	cmd.Run()
-
-	cmd = exec.Command("sysctl", "-w", "net.core.wmem_max=134217728")
-	cmd.Run()
-
-	return nil
-}
@@ -0,0 +1,215 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// NetworkChange is reported by Monitor once the network settles after a
+// change of the default route, or after a resume from suspend.
+type NetworkChange struct {
+	Reason   string    `json:"reason"`
+	Previous string    `json:"previous"`
+	Current  string    `json:"current"`
+	At       time.Time `json:"at"`
+}
+
+// Monitor watches netlink link, address and route events. Events are
+// debounced: OnChange runs only after Debounce has passed without further
+// events, and only when the default route actually changed, so a flapping
+// link does not cause a reconnect storm. Changes on the tunnel interface
+// itself are ignored.
+type Monitor struct {
+	Interface string
+	Debounce  time.Duration
+	OnChange  func(NetworkChange)
+}
+
+func NewMonitor(iface string, debounce time.Duration, onChange func(NetworkChange)) *Monitor {
+	return &Monitor{
+		Interface: iface,
+		Debounce:  debounce,
+		OnChange:  onChange,
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	fd, err := subscribeRouteEvents()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	events := make(chan struct{}, 1)
+	go m.readEvents(ctx, fd, events)
+
+	current := m.defaultRouteState()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	const tick = 5 * time.Second
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	last := time.Now()
+
+	pendingReason := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-events:
+			if pendingReason == "" {
+				pendingReason = "default route changed"
+			}
+			timer.Reset(m.Debounce)
+
+		case now := <-ticker.C:
+			// The monotonic clock stops during suspend while the wall
+			// clock keeps going, so a gap between them means a resume.
+			if now.Round(0).Sub(last.Round(0))-now.Sub(last) > tick {
+				pendingReason = "resumed from suspend"
+				timer.Reset(m.Debounce)
+			}
+			last = now
+
+		case <-timer.C:
+			next := m.defaultRouteState()
+			if next != current || pendingReason == "resumed from suspend" {
+				m.OnChange(NetworkChange{Reason: pendingReason, Previous: current, Current: next, At: time.Now()})
+			}
+			current, pendingReason = next, ""
+		}
+	}
+}
+
+func subscribeRouteEvents() (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+
+	groups := uint32(rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("failed to subscribe to netlink events: %w", err)
+	}
+
+	// Wake up regularly so Run can stop the reader.
+	timeout := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("failed to set netlink timeout: %w", err)
+	}
+	return fd, nil
+}
+
+func (m *Monitor) readEvents(ctx context.Context, fd int, events chan<- struct{}) {
+	tunnelIndex := 0
+	if ifi, err := net.InterfaceByName(m.Interface); err == nil {
+		tunnelIndex = ifi.Index
+	}
+
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err != syscall.EAGAIN && err != syscall.EINTR {
+				log.Printf("Warning: netlink monitor: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if tunnelIndex != 0 && eventIndex(msg) == tunnelIndex {
+				continue
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// eventIndex returns the interface index a link or address event is about,
+// or 0 for other events.
+func eventIndex(msg syscall.NetlinkMessage) int {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(msg.Data) >= syscall.SizeofIfInfomsg {
+			return int(int32(nativeUint32(msg.Data[4:8])))
+		}
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(msg.Data) >= syscall.SizeofIfAddrmsg {
+			return int(nativeUint32(msg.Data[4:8]))
+		}
+	}
+	return 0
+}
+
+// defaultRouteState describes the physical uplink: the preferred default
+// route of each family in the main table with the device's addresses.
+func (m *Monitor) defaultRouteState() string {
+	routes, err := ListRoutes()
+	if err != nil {
+		return ""
+	}
+
+	best := make(map[string]Route)
+	for _, route := range routes {
+		if route.Table != RouteTableMain || !route.IsDefault() || route.Dev == m.Interface || route.Type != "unicast" {
+			continue
+		}
+		if current, ok := best[route.Family]; !ok || route.Metric < current.Metric {
+			best[route.Family] = route
+		}
+	}
+
+	var parts, devices []string
+	for _, family := range []string{"inet", "inet6"} {
+		route, ok := best[family]
+		if !ok {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s via %s dev %s", family, route.Gateway, route.Dev))
+		if len(devices) == 0 || devices[0] != route.Dev {
+			devices = append(devices, route.Dev)
+		}
+	}
+
+	// A new DHCP lease behind the same gateway changes only the address.
+	for _, dev := range devices {
+		ifi, err := net.InterfaceByName(dev)
+		if err != nil {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		var list []string
+		for _, addr := range addrs {
+			list = append(list, addr.String())
+		}
+		sort.Strings(list)
+		parts = append(parts, dev+" "+strings.Join(list, " "))
+	}
+
+	if len(parts) == 0 {
+		return "no default route"
+	}
+	return strings.Join(parts, "; ")
+}
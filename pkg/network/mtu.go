@@ -0,0 +1,140 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	wireGuardOverhead = 32
+	udpHeaderLen      = 8
+	icmpHeaderLen     = 8
+	ipv4HeaderLen     = 20
+	ipv6HeaderLen     = 40
+
+	// IPv6 requires every link, the tunnel included, to carry 1280 bytes.
+	minIPv6MTU = 1280
+)
+
+type MTUResult struct {
+	Endpoint  string `json:"endpoint"`
+	Address   string `json:"address"`
+	IPv6      bool   `json:"ipv6"`
+	PathMTU   int    `json:"path_mtu"`
+	Overhead  int    `json:"overhead"`
+	TunnelMTU int    `json:"tunnel_mtu"`
+	Probes    int    `json:"probes"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// DiscoverPathMTU binary-searches the largest ICMP echo that reaches the
+// endpoint with the don't-fragment bit set, then subtracts the outer IP, UDP
+// and WireGuard headers to get the best tunnel MTU.
+func (m *Manager) DiscoverPathMTU(endpoint string, maxMTU int) (*MTUResult, error) {
+	host := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	}
+
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	conn, err := m.openICMP(addr.IP)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.setDontFragment(); err != nil {
+		return nil, err
+	}
+
+	result := &MTUResult{
+		Endpoint: endpoint,
+		Address:  addr.IP.String(),
+		IPv6:     conn.v6,
+	}
+
+	ipHeader := ipv4HeaderLen
+	low := 576
+	if conn.v6 {
+		ipHeader = ipv6HeaderLen
+		low = minIPv6MTU
+	}
+	if maxMTU <= 0 {
+		maxMTU = 1500
+	}
+
+	// probe reports whether a packet of the given size on the wire arrives.
+	seq := uint16(0)
+	probe := func(size int) bool {
+		pad := size - ipHeader - icmpHeaderLen - len(conn.nonce)
+		for attempt := 0; attempt < 2; attempt++ {
+			seq++
+			result.Probes++
+			_, err := conn.echoPadded(seq, pad, time.Second)
+			if err == nil {
+				return true
+			}
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false
+			}
+		}
+		return false
+	}
+
+	if !probe(low) {
+		return nil, fmt.Errorf("%s does not answer ICMP echo requests at %d bytes", result.Address, low)
+	}
+
+	high := maxMTU
+	if probe(high) {
+		low = high
+	} else {
+		for high-low > 1 {
+			mid := (low + high) / 2
+			if probe(mid) {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+	}
+
+	result.PathMTU = low
+	result.Overhead = ipHeader + udpHeaderLen + wireGuardOverhead
+	result.TunnelMTU = result.PathMTU - result.Overhead
+	if result.TunnelMTU < minIPv6MTU {
+		result.Warning = fmt.Sprintf("tunnel MTU %d is below %d, IPv6 cannot be carried through the tunnel", result.TunnelMTU, minIPv6MTU)
+	}
+
+	return result, nil
+}
+
+func (m *Manager) SetInterfaceMTU(mtu int) error {
+	cmd := exec.Command("ip", "link", "set", "dev", m.interfaceName, "mtu", strconv.Itoa(mtu))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set MTU on %s: %s", m.interfaceName, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (c *icmpConn) setDontFragment() error {
+	level, opt, value := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+	if c.v6 {
+		level, opt, value = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+	}
+	if err := syscall.SetsockoptInt(c.fd, level, opt, value); err != nil {
+		return fmt.Errorf("failed to set don't-fragment: %w", err)
+	}
+	return nil
+}
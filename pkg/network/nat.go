@@ -0,0 +1,50 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// NATResult tells whether the physical network translates addresses. Local
+// is the source address of the physical default route and Public the address
+// the trace endpoint saw, when it had to be asked.
+type NATResult struct {
+	BehindNAT bool   `json:"behind_nat"`
+	Local     string `json:"local"`
+	Public    string `json:"public,omitempty"`
+}
+
+// DetectNAT compares the IPv4 source address of the physical path with the
+// address the trace endpoint reports. A private or carrier-grade NAT source
+// address is translated without asking.
+func (m *Manager) DetectNAT(traceURL string) (*NATResult, error) {
+	physical, err := m.PhysicalPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Connecting a UDP socket selects the source address without sending.
+	conn, err := physical.dialer("udp", time.Second).Dial("udp4", "1.1.1.1:53")
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine source address: %w", err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	result := &NATResult{Local: local.String()}
+	if local.IsPrivate() || local.IsLinkLocalUnicast() || carrierGradeNAT.Contains(local) {
+		result.BehindNAT = true
+		return result, nil
+	}
+
+	trace, err := physical.Trace(traceURL)
+	if err != nil {
+		return result, err
+	}
+	result.Public = trace.IP
+	result.BehindNAT = !local.Equal(net.ParseIP(trace.IP))
+	return result, nil
+}
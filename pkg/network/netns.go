@@ -0,0 +1,138 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Namespace is a network namespace whose only uplink is the tunnel. The
+// WireGuard interface is created in the initial namespace, so its UDP socket
+// stays on the physical network, and then moved inside.
+type Namespace struct {
+	Name      string
+	Interface string
+	DNS       []string
+}
+
+func NewNamespace(name, iface string, dns []string) *Namespace {
+	return &Namespace{
+		Name:      name,
+		Interface: iface,
+		DNS:       dns,
+	}
+}
+
+func (n *Namespace) Exists() bool {
+	_, err := os.Stat(filepath.Join("/run/netns", n.Name))
+	return err == nil
+}
+
+// Setup creates the namespace, moves the already configured WireGuard
+// interface into it, assigns addresses and default routes and writes the
+// namespace's resolv.conf.
+func (n *Namespace) Setup(addresses []string, mtu int) error {
+	if !n.Exists() {
+		if err := ip("netns", "add", n.Name); err != nil {
+			return fmt.Errorf("failed to create namespace %s: %w", n.Name, err)
+		}
+	}
+	if err := ip("-n", n.Name, "link", "set", "lo", "up"); err != nil {
+		return fmt.Errorf("failed to bring up loopback in %s: %w", n.Name, err)
+	}
+	if err := ip("link", "set", n.Interface, "netns", n.Name); err != nil {
+		return fmt.Errorf("failed to move %s into %s: %w", n.Interface, n.Name, err)
+	}
+
+	families := make(map[string]bool)
+	for _, addr := range addresses {
+		ipAddr, _, err := net.ParseCIDR(addr)
+		if err != nil {
+			return fmt.Errorf("invalid interface address %q: %w", addr, err)
+		}
+		family := "-4"
+		if ipAddr.To4() == nil {
+			family = "-6"
+		}
+		families[family] = true
+		if err := ip("-n", n.Name, family, "addr", "add", addr, "dev", n.Interface); err != nil {
+			return fmt.Errorf("failed to add address %s: %w", addr, err)
+		}
+	}
+
+	if err := ip("-n", n.Name, "link", "set", n.Interface, "mtu", strconv.Itoa(mtu), "up"); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", n.Interface, err)
+	}
+	for _, family := range []string{"-4", "-6"} {
+		if !families[family] {
+			continue
+		}
+		if err := ip("-n", n.Name, family, "route", "add", "default", "dev", n.Interface); err != nil {
+			return fmt.Errorf("failed to add default route: %w", err)
+		}
+	}
+
+	return n.writeResolvConf()
+}
+
+// ip netns exec bind-mounts /etc/netns/<name>/resolv.conf over
+// /etc/resolv.conf for programs in the namespace.
+func (n *Namespace) writeResolvConf() error {
+	dir := filepath.Join("/etc/netns", n.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Written by darp for network namespace " + n.Name + "\n")
+	for _, server := range n.DNS {
+		b.WriteString("nameserver " + server + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "resolv.conf"), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write namespace resolv.conf: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the namespace, which also destroys the WireGuard interface
+// inside it, and its resolv.conf.
+func (n *Namespace) Delete() error {
+	if n.Exists() {
+		if err := ip("netns", "del", n.Name); err != nil {
+			return fmt.Errorf("failed to delete namespace %s: %w", n.Name, err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join("/etc/netns", n.Name)); err != nil {
+		return fmt.Errorf("failed to remove namespace configuration: %w", err)
+	}
+	return nil
+}
+
+// Command returns a command that runs args inside the namespace, as
+// username when it is not empty.
+func (n *Namespace) Command(username string, args ...string) *exec.Cmd {
+	cmdArgs := []string{"netns", "exec", n.Name}
+	if username != "" {
+		cmdArgs = append(cmdArgs, "runuser", "-u", username, "--")
+	}
+	return exec.Command("ip", append(cmdArgs, args...)...)
+}
+
+// Processes lists the PIDs running inside the namespace.
+func (n *Namespace) Processes() ([]int, error) {
+	output, err := exec.Command("ip", "netns", "pids", n.Name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes in %s: %w", n.Name, err)
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(output)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
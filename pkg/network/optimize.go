@@ -0,0 +1,389 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tunable is a single setting applied by darp optimize: a sysctl key, or
+// the qdisc ("qdisc:<iface>") or MTU ("mtu:<iface>") of an interface.
+// Check, when set, reports without changing anything why the tunable cannot
+// be applied on this system. Requires and Discover only run when applying:
+// Requires prepares the system, e.g. by loading a module, and Discover
+// determines Value, e.g. by probing the network.
+type Tunable struct {
+	Name     string
+	Key      string
+	Value    string
+	Check    func() error
+	Requires func() error
+	Discover func() (string, error)
+}
+
+type TunableStatus string
+
+const (
+	TunableApplied   TunableStatus = "applied"
+	TunablePending   TunableStatus = "pending"
+	TunableUnchanged TunableStatus = "unchanged"
+	TunableSkipped   TunableStatus = "skipped"
+	TunableFailed    TunableStatus = "failed"
+	TunableRestored  TunableStatus = "restored"
+)
+
+type TunableResult struct {
+	Name     string        `json:"name"`
+	Key      string        `json:"key"`
+	Previous string        `json:"previous,omitempty"`
+	Value    string        `json:"value"`
+	Status   TunableStatus `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+}
+
+var tunableNames = map[string]string{
+	"net.ipv4.tcp_congestion_control":    "Congestion control",
+	"net.core.default_qdisc":             "Default qdisc",
+	"net.core.rmem_max":                  "Receive buffer limit",
+	"net.core.wmem_max":                  "Send buffer limit",
+	"net.ipv4.tcp_rmem":                  "TCP receive buffers",
+	"net.ipv4.tcp_wmem":                  "TCP send buffers",
+	"net.ipv4.tcp_mtu_probing":           "TCP MTU probing",
+	"net.ipv4.tcp_notsent_lowat":         "TCP unsent low watermark",
+	"net.ipv4.tcp_fastopen":              "TCP Fast Open",
+	"net.ipv4.tcp_slow_start_after_idle": "Slow start after idle",
+}
+
+// SysctlTunable returns a tunable for a sysctl key, with a readable name
+// and preconditions for the keys that have them.
+func SysctlTunable(key, value string) Tunable {
+	tunable := Tunable{Name: key, Key: key, Value: value}
+	if name, ok := tunableNames[key]; ok {
+		tunable.Name = name
+	}
+	if key == "net.ipv4.tcp_congestion_control" {
+		tunable.Check = func() error { return checkCongestionControl(value) }
+		tunable.Requires = func() error { return requireCongestionControl(value) }
+	}
+	return tunable
+}
+
+func QdiscTunable(iface, qdisc string) Tunable {
+	return Tunable{Name: "Qdisc on " + iface, Key: "qdisc:" + iface, Value: qdisc}
+}
+
+func MTUTunable(iface string, mtu int) Tunable {
+	return Tunable{Name: "MTU on " + iface, Key: "mtu:" + iface, Value: strconv.Itoa(mtu)}
+}
+
+// DiscoveredMTUTunable sets the MTU of iface to whatever discover returns
+// when the tunable is applied.
+func DiscoveredMTUTunable(iface string, discover func() (int, error)) Tunable {
+	tunable := Tunable{Name: "MTU on " + iface, Key: "mtu:" + iface, Value: "discover"}
+	tunable.Discover = func() (string, error) {
+		mtu, err := discover()
+		if err != nil {
+			return "", fmt.Errorf("path MTU discovery failed: %w", err)
+		}
+		return strconv.Itoa(mtu), nil
+	}
+	return tunable
+}
+
+// checkCongestionControl asks modprobe for a dry run, so nothing is loaded.
+func checkCongestionControl(name string) error {
+	if strings.Contains(readSysctl("net.ipv4.tcp_available_congestion_control"), name) {
+		return nil
+	}
+	if err := exec.Command("modprobe", "--dry-run", "--quiet", "tcp_"+name).Run(); err != nil {
+		return fmt.Errorf("tcp_%s module not available", name)
+	}
+	return nil
+}
+
+func requireCongestionControl(name string) error {
+	if strings.Contains(readSysctl("net.ipv4.tcp_available_congestion_control"), name) {
+		return nil
+	}
+	if err := exec.Command("modprobe", "tcp_"+name).Run(); err != nil {
+		return fmt.Errorf("tcp_%s module not available", name)
+	}
+	if !strings.Contains(readSysctl("net.ipv4.tcp_available_congestion_control"), name) {
+		return fmt.Errorf("%s not listed in tcp_available_congestion_control", name)
+	}
+	return nil
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
+
+func readSysctl(key string) string {
+	data, err := os.ReadFile(sysctlPath(key))
+	if err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(string(data)), " ")
+}
+
+func tunableSupported(key string) error {
+	if kind, iface, ok := strings.Cut(key, ":"); ok && (kind == "qdisc" || kind == "mtu") {
+		if _, err := net.InterfaceByName(iface); err != nil {
+			return fmt.Errorf("interface %s is not up", iface)
+		}
+		return nil
+	}
+	if _, err := os.Stat(sysctlPath(key)); err != nil {
+		return fmt.Errorf("not supported by this kernel")
+	}
+	return nil
+}
+
+func readTunable(key string) string {
+	kind, iface, _ := strings.Cut(key, ":")
+	switch kind {
+	case "qdisc":
+		output, err := exec.Command("tc", "qdisc", "show", "dev", iface, "root").Output()
+		if err != nil {
+			return ""
+		}
+		fields := strings.Fields(string(output))
+		if len(fields) < 2 {
+			return ""
+		}
+		return fields[1]
+	case "mtu":
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return ""
+		}
+		return strconv.Itoa(ifi.MTU)
+	}
+	return readSysctl(key)
+}
+
+func writeTunable(key, value string) error {
+	var cmd *exec.Cmd
+	kind, iface, _ := strings.Cut(key, ":")
+	switch {
+	case kind == "qdisc" && value == "noqueue":
+		// Deleting the root qdisc restores the interface default.
+		cmd = exec.Command("tc", "qdisc", "del", "dev", iface, "root")
+	case kind == "qdisc":
+		cmd = exec.Command("tc", "qdisc", "replace", "dev", iface, "root", value)
+	case kind == "mtu":
+		cmd = exec.Command("ip", "link", "set", "dev", iface, "mtu", value)
+	default:
+		cmd = exec.Command("sysctl", "-w", key+"="+value)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// OptimizeNetwork applies tunables and records the values they replaced in
+// snapshotPath so RevertOptimizations can restore them. Values already in the
+// snapshot are kept, so applying twice still reverts to the original state.
+func (m *Manager) OptimizeNetwork(tunables []Tunable, snapshotPath string) ([]TunableResult, error) {
+	snapshot, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := m.optimize(tunables, snapshot, true)
+
+	if err := saveSnapshot(snapshotPath, snapshot); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// PlanOptimizations reports what OptimizeNetwork would change without
+// touching the system: no modules are loaded and nothing is probed, so
+// discovered values are reported as pending.
+func (m *Manager) PlanOptimizations(tunables []Tunable) []TunableResult {
+	return m.optimize(tunables, nil, false)
+}
+
+func (m *Manager) optimize(tunables []Tunable, snapshot map[string]string, apply bool) []TunableResult {
+	var results []TunableResult
+	for _, tunable := range tunables {
+		result := TunableResult{Name: tunable.Name, Key: tunable.Key, Value: tunable.Value}
+
+		if err := tunableSupported(tunable.Key); err != nil {
+			result.Status, result.Detail = TunableSkipped, err.Error()
+			results = append(results, result)
+			continue
+		}
+		if tunable.Check != nil {
+			if err := tunable.Check(); err != nil {
+				result.Status, result.Detail = TunableSkipped, err.Error()
+				results = append(results, result)
+				continue
+			}
+		}
+
+		result.Previous = readTunable(tunable.Key)
+		if !apply && tunable.Discover != nil {
+			result.Status, result.Detail = TunablePending, "determined when applied"
+			results = append(results, result)
+			continue
+		}
+
+		if apply && tunable.Requires != nil {
+			if err := tunable.Requires(); err != nil {
+				result.Status, result.Detail = TunableSkipped, err.Error()
+				results = append(results, result)
+				continue
+			}
+		}
+		if apply && tunable.Discover != nil {
+			value, err := tunable.Discover()
+			if err != nil {
+				result.Status, result.Detail = TunableSkipped, err.Error()
+				results = append(results, result)
+				continue
+			}
+			tunable.Value, result.Value = value, value
+		}
+
+		if result.Previous == tunable.Value {
+			result.Status = TunableUnchanged
+			results = append(results, result)
+			continue
+		}
+
+		if !apply {
+			result.Status = TunablePending
+			results = append(results, result)
+			continue
+		}
+
+		if err := writeTunable(tunable.Key, tunable.Value); err != nil {
+			result.Status, result.Detail = TunableFailed, err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		if _, ok := snapshot[tunable.Key]; !ok {
+			snapshot[tunable.Key] = result.Previous
+		}
+		result.Status = TunableApplied
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// RevertOptimizations restores every value recorded in snapshotPath and
+// removes the snapshot and, when given, the persisted sysctl.d file.
+func (m *Manager) RevertOptimizations(snapshotPath, persistPath string) ([]TunableResult, error) {
+	snapshot, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot) == 0 {
+		return nil, fmt.Errorf("no optimization snapshot found at %s", snapshotPath)
+	}
+
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var results []TunableResult
+	failed := map[string]string{}
+	for _, key := range keys {
+		result := TunableResult{Name: key, Key: key, Previous: readTunable(key), Value: snapshot[key]}
+		if err := writeTunable(key, snapshot[key]); err != nil {
+			result.Status, result.Detail = TunableFailed, err.Error()
+			failed[key] = snapshot[key]
+		} else {
+			result.Status = TunableRestored
+		}
+		results = append(results, result)
+	}
+
+	if persistPath != "" {
+		if err := os.Remove(persistPath); err != nil && !os.IsNotExist(err) {
+			return results, fmt.Errorf("failed to remove %s: %w", persistPath, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		if err := saveSnapshot(snapshotPath, failed); err != nil {
+			return results, err
+		}
+		return results, fmt.Errorf("failed to restore %d tunable(s)", len(failed))
+	}
+	if err := os.Remove(snapshotPath); err != nil && !os.IsNotExist(err) {
+		return results, fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	return results, nil
+}
+
+// PersistOptimizations writes the applied and unchanged sysctl tunables to a
+// sysctl.d file so they survive a reboot. Interface settings are not
+// persisted; they are reapplied on the next darp optimize.
+func PersistOptimizations(results []TunableResult, path string) error {
+	var b strings.Builder
+	b.WriteString("# Written by darp optimize; removed by darp optimize --revert\n")
+	for _, result := range results {
+		if strings.Contains(result.Key, ":") {
+			continue
+		}
+		if result.Status == TunableApplied || result.Status == TunableUnchanged {
+			fmt.Fprintf(&b, "%s = %s\n", result.Key, result.Value)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadSnapshot(path string) (map[string]string, error) {
+	snapshot := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func saveSnapshot(path string, snapshot map[string]string) error {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
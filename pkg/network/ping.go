@@ -0,0 +1,363 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type ProbeOptions struct {
+	Protocol string
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+	Port     int
+}
+
+// DefaultProbeOptions leaves Protocol empty so each target is probed with
+// its own protocol.
+func DefaultProbeOptions() ProbeOptions {
+	return ProbeOptions{
+		Count:    5,
+		Interval: 200 * time.Millisecond,
+		Timeout:  2 * time.Second,
+		Port:     80,
+	}
+}
+
+type LatencyStats struct {
+	Target   string        `json:"target"`
+	Address  string        `json:"address,omitempty"`
+	Protocol string        `json:"protocol"`
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Loss     float64       `json:"loss"`
+	Min      time.Duration `json:"min"`
+	Avg      time.Duration `json:"avg"`
+	Max      time.Duration `json:"max"`
+	StdDev   time.Duration `json:"stddev"`
+	Jitter   time.Duration `json:"jitter"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// LatencyTargets keeps the targets that can be probed for latency.
+func LatencyTargets(targets []Target) []Target {
+	var out []Target
+	for _, target := range targets {
+		if target.Protocol == "icmp" || target.Protocol == "tcp" {
+			out = append(out, target)
+		}
+	}
+	return out
+}
+
+// MeasureLatency probes every target concurrently, sending opts.Count probes
+// per target opts.Interval apart. A non-empty opts.Protocol overrides the
+// targets' own protocols.
+func (m *Manager) MeasureLatency(targets []Target, opts ProbeOptions) []LatencyStats {
+	if opts.Count <= 0 {
+		opts.Count = 1
+	}
+
+	results := make([]LatencyStats, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			results[i] = m.probeTarget(target, opts)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (m *Manager) probeTarget(target Target, opts ProbeOptions) LatencyStats {
+	protocol := opts.Protocol
+	if protocol == "" {
+		protocol = target.Protocol
+	}
+	port := target.Port
+	if port == 0 {
+		port = opts.Port
+	}
+
+	stats := LatencyStats{Target: target.Label(), Protocol: protocol}
+
+	addr, err := net.ResolveIPAddr(m.familyNetwork("ip"), target.Host)
+	if err != nil {
+		stats.Error = fmt.Sprintf("failed to resolve %s: %v", target.Host, err)
+		return stats
+	}
+	stats.Address = addr.IP.String()
+
+	var probe func(seq int) (time.Duration, error)
+	switch protocol {
+	case "tcp":
+		endpoint := net.JoinHostPort(addr.IP.String(), fmt.Sprint(port))
+		probe = func(int) (time.Duration, error) {
+			return m.tcpProbe(endpoint, opts.Timeout)
+		}
+	case "icmp", "":
+		stats.Protocol = "icmp"
+		conn, err := m.openICMP(addr.IP)
+		if err != nil {
+			stats.Error = err.Error()
+			return stats
+		}
+		defer conn.Close()
+		probe = func(seq int) (time.Duration, error) {
+			return conn.echo(uint16(seq), opts.Timeout)
+		}
+	default:
+		stats.Error = fmt.Sprintf("unsupported probe protocol %q", protocol)
+		return stats
+	}
+
+	var rtts []time.Duration
+	var lastErr error
+	for seq := 0; seq < opts.Count; seq++ {
+		if seq > 0 {
+			time.Sleep(opts.Interval)
+		}
+		stats.Sent++
+		rtt, err := probe(seq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+
+	stats.Received = len(rtts)
+	stats.Loss = 100 * float64(stats.Sent-stats.Received) / float64(stats.Sent)
+	if len(rtts) == 0 {
+		if lastErr != nil {
+			stats.Error = lastErr.Error()
+		}
+		return stats
+	}
+
+	summarize(&stats, rtts)
+	return stats
+}
+
+func summarize(stats *LatencyStats, rtts []time.Duration) {
+	var sum, jitter float64
+	stats.Min, stats.Max = rtts[0], rtts[0]
+	for i, rtt := range rtts {
+		sum += float64(rtt)
+		if rtt < stats.Min {
+			stats.Min = rtt
+		}
+		if rtt > stats.Max {
+			stats.Max = rtt
+		}
+		if i > 0 {
+			jitter += math.Abs(float64(rtt - rtts[i-1]))
+		}
+	}
+
+	mean := sum / float64(len(rtts))
+	var variance float64
+	for _, rtt := range rtts {
+		variance += (float64(rtt) - mean) * (float64(rtt) - mean)
+	}
+
+	stats.Avg = time.Duration(mean)
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(rtts))))
+	if len(rtts) > 1 {
+		stats.Jitter = time.Duration(jitter / float64(len(rtts)-1))
+	}
+}
+
+func (m *Manager) tcpProbe(endpoint string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	conn, err := m.dialer("tcp", timeout).Dial(m.familyNetwork("tcp"), endpoint)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+type icmpConn struct {
+	fd     int
+	v6     bool
+	raw    bool
+	id     uint16
+	nonce  []byte
+	target syscall.Sockaddr
+}
+
+// openICMP prefers unprivileged ping sockets (net.ipv4.ping_group_range) and
+// falls back to raw sockets, which need CAP_NET_RAW.
+func (m *Manager) openICMP(ip net.IP) (*icmpConn, error) {
+	conn := &icmpConn{v6: ip.To4() == nil}
+
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if conn.v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa := &syscall.SockaddrInet6{}
+		copy(sa.Addr[:], ip.To16())
+		conn.target = sa
+	} else {
+		sa := &syscall.SockaddrInet4{}
+		copy(sa.Addr[:], ip.To4())
+		conn.target = sa
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		fd, err = syscall.Socket(family, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ICMP socket (enable net.ipv4.ping_group_range or run as root): %w", err)
+		}
+		conn.raw = true
+	}
+	conn.fd = fd
+
+	if err := m.bindICMP(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.nonce = make([]byte, 8)
+	rand.Read(conn.nonce)
+	conn.id = binary.BigEndian.Uint16(conn.nonce)
+	return conn, nil
+}
+
+func (m *Manager) bindICMP(conn *icmpConn) error {
+	if device := m.binding.Device; device != "" {
+		if err := syscall.SetsockoptString(conn.fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device); err != nil {
+			return fmt.Errorf("failed to bind to %s: %w", device, err)
+		}
+	}
+
+	if m.binding.Source == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(m.binding.Source)
+	var sa syscall.Sockaddr
+	if conn.v6 {
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], ip.To16())
+		sa = sa6
+	} else {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], ip.To4())
+		sa = sa4
+	}
+	if err := syscall.Bind(conn.fd, sa); err != nil {
+		return fmt.Errorf("failed to bind to %s: %w", m.binding.Source, err)
+	}
+	return nil
+}
+
+func (c *icmpConn) Close() error {
+	return syscall.Close(c.fd)
+}
+
+func (c *icmpConn) echo(seq uint16, timeout time.Duration) (time.Duration, error) {
+	return c.echoPadded(seq, 0, timeout)
+}
+
+// echoPadded sends an echo request carrying pad extra payload bytes.
+func (c *icmpConn) echoPadded(seq uint16, pad int, timeout time.Duration) (time.Duration, error) {
+	request := c.echoRequest(seq, pad)
+
+	start := time.Now()
+	if err := syscall.Sendto(c.fd, request, 0, c.target); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %w", err)
+	}
+
+	deadline := start.Add(timeout)
+	buf := make([]byte, 65536)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, fmt.Errorf("echo request timed out")
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return 0, fmt.Errorf("failed to receive echo reply: %w", err)
+		}
+
+		if c.isReply(buf[:n], seq) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+func (c *icmpConn) echoRequest(seq uint16, pad int) []byte {
+	msg := make([]byte, 8+len(c.nonce)+pad)
+	msg[0] = 8
+	if c.v6 {
+		msg[0] = 128
+	}
+	binary.BigEndian.PutUint16(msg[4:], c.id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], c.nonce)
+
+	// The kernel fills in the ICMPv6 checksum itself.
+	if !c.v6 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	return msg
+}
+
+func (c *icmpConn) isReply(msg []byte, seq uint16) bool {
+	// Raw IPv4 sockets deliver the IP header as well.
+	if c.raw && !c.v6 && len(msg) > 0 {
+		ihl := int(msg[0]&0x0f) * 4
+		if ihl > len(msg) {
+			return false
+		}
+		msg = msg[ihl:]
+	}
+	if len(msg) < 8+len(c.nonce) {
+		return false
+	}
+
+	replyType := byte(0)
+	if c.v6 {
+		replyType = 129
+	}
+	if msg[0] != replyType || binary.BigEndian.Uint16(msg[6:]) != seq {
+		return false
+	}
+	// Ping sockets rewrite the identifier, so only raw sockets can check it.
+	if c.raw && binary.BigEndian.Uint16(msg[4:]) != c.id {
+		return false
+	}
+	return bytes.Equal(msg[8:8+len(c.nonce)], c.nonce)
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
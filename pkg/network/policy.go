@@ -0,0 +1,239 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PolicyRouting sends traffic through the tunnel with a dedicated routing
+// table instead of replacing the default route:
+//
+//	ip rule add not fwmark <mark> table <table> priority <priority>
+//	ip rule add table main suppress_prefixlength 0 priority <priority-1>
+//
+// WireGuard marks its own UDP packets, so they keep using the main table.
+// The suppress rule lets more specific main-table routes, such as the LAN or
+// another VPN, win over the tunnel's default route.
+//
+// When AppMark is set only packets carrying it use the table
+// ("fwmark <AppMark> table <table>"), which is how AppTunnel sends selected
+// applications through the tunnel.
+//
+// With BlockIPv6 the IPv6 prefixes are installed as unreachable routes, so
+// IPv6 traffic fails immediately instead of leaving outside the tunnel.
+//
+// Sysctls changed by Install are recorded in SnapshotFile, when set, and
+// restored by Remove, which usually runs in a later darp process.
+type PolicyRouting struct {
+	Interface    string
+	Table        int
+	FwMark       uint32
+	Priority     int
+	AppMark      uint32
+	BlockIPv6    bool
+	SnapshotFile string
+}
+
+const srcValidMark = "net.ipv4.conf.all.src_valid_mark"
+
+func NewPolicyRouting(iface string, table int, fwmark uint32, priority int) *PolicyRouting {
+	return &PolicyRouting{
+		Interface: iface,
+		Table:     table,
+		FwMark:    fwmark,
+		Priority:  priority,
+	}
+}
+
+// Install routes allowedIPs through the tunnel interface in the dedicated
+// table and adds the rules for each address family present. On failure
+// everything installed so far is removed.
+func (p *PolicyRouting) Install(allowedIPs []string) error {
+	families := make(map[string]bool)
+	for _, prefix := range allowedIPs {
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IP %q: %w", prefix, err)
+		}
+		family := "-4"
+		if ipNet.IP.To4() == nil {
+			family = "-6"
+		}
+		families[family] = true
+
+		route := []string{family, "route", "replace", ipNet.String(), "dev", p.Interface}
+		if family == "-6" && p.BlockIPv6 {
+			route = []string{family, "route", "replace", "unreachable", ipNet.String()}
+		}
+		if err := ip(append(route, "table", strconv.Itoa(p.Table))...); err != nil {
+			p.Remove()
+			return fmt.Errorf("failed to add route %s: %w", ipNet, err)
+		}
+	}
+
+	for _, family := range []string{"-4", "-6"} {
+		if !families[family] {
+			continue
+		}
+		if err := p.installRules(family); err != nil {
+			p.Remove()
+			return err
+		}
+	}
+
+	// Replies to marked packets must pass reverse path filtering.
+	if err := p.setSysctl(srcValidMark, "1"); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	return nil
+}
+
+// setSysctl records the current value of key, unless an earlier Install
+// already did, and sets it to value.
+func (p *PolicyRouting) setSysctl(key, value string) error {
+	if p.SnapshotFile != "" {
+		snapshot, err := loadSnapshot(p.SnapshotFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := snapshot[key]; !ok {
+			snapshot[key] = readSysctl(key)
+			if err := saveSnapshot(p.SnapshotFile, snapshot); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeTunable(key, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// restoreSysctls writes back the values recorded by setSysctl and removes
+// the snapshot.
+func (p *PolicyRouting) restoreSysctls() error {
+	if p.SnapshotFile == "" {
+		return nil
+	}
+	snapshot, err := loadSnapshot(p.SnapshotFile)
+	if err != nil {
+		return err
+	}
+	failed := map[string]string{}
+	for key, value := range snapshot {
+		if value == "" || readSysctl(key) == value {
+			continue
+		}
+		if err := writeTunable(key, value); err != nil {
+			failed[key] = value
+		}
+	}
+	if len(failed) > 0 {
+		if err := saveSnapshot(p.SnapshotFile, failed); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to restore %d sysctl(s)", len(failed))
+	}
+	if err := os.Remove(p.SnapshotFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	return nil
+}
+
+func (p *PolicyRouting) installRules(family string) error {
+	// Rules are not replaced by ip rule add, so clear leftovers from a
+	// previous run first.
+	p.removeRules(family)
+
+	selector := []string{"not", "fwmark", strconv.FormatUint(uint64(p.FwMark), 10)}
+	if p.AppMark != 0 {
+		selector = []string{"fwmark", strconv.FormatUint(uint64(p.AppMark), 10)}
+	}
+	args := append([]string{family, "rule", "add"}, selector...)
+	args = append(args, "table", strconv.Itoa(p.Table), "priority", strconv.Itoa(p.Priority))
+	if err := ip(args...); err != nil {
+		return fmt.Errorf("failed to add fwmark rule: %w", err)
+	}
+	if err := ip(family, "rule", "add", "table", "main", "suppress_prefixlength", "0",
+		"priority", strconv.Itoa(p.Priority-1)); err != nil {
+		return fmt.Errorf("failed to add suppress_prefixlength rule: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the rules, flushes the dedicated table and restores the
+// sysctls Install changed. It is safe to call when nothing is installed.
+func (p *PolicyRouting) Remove() error {
+	var errs []string
+	for _, family := range []string{"-4", "-6"} {
+		if err := p.removeRules(family); err != nil {
+			errs = append(errs, err.Error())
+		}
+		// Flushing fails when the family is disabled; there is nothing
+		// to remove then.
+		ip(family, "route", "flush", "table", strconv.Itoa(p.Table))
+	}
+	if err := p.restoreSysctls(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove policy routing: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (p *PolicyRouting) removeRules(family string) error {
+	rules, err := ListRules()
+	if err != nil {
+		return err
+	}
+
+	want := "inet"
+	if family == "-6" {
+		want = "inet6"
+	}
+	for _, rule := range rules {
+		if rule.Family != want || !p.owns(rule) {
+			continue
+		}
+		if err := ip(family, "rule", "del", "priority", strconv.Itoa(rule.Priority)); err != nil {
+			return fmt.Errorf("failed to delete rule %d: %w", rule.Priority, err)
+		}
+	}
+	return nil
+}
+
+func (p *PolicyRouting) owns(rule Rule) bool {
+	if rule.Priority == p.Priority && rule.Table == p.Table {
+		return true
+	}
+	return rule.Priority == p.Priority-1 && rule.Table == RouteTableMain && rule.SuppressPrefixlen == 0
+}
+
+// Installed reports whether the fwmark rule is present for any family.
+func (p *PolicyRouting) Installed() bool {
+	rules, err := ListRules()
+	if err != nil {
+		return false
+	}
+	for _, rule := range rules {
+		if rule.Priority == p.Priority && rule.Table == p.Table {
+			return true
+		}
+	}
+	return false
+}
+
+func ip(args ...string) error {
+	output, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
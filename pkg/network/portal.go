@@ -0,0 +1,71 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type PortalResult struct {
+	URL       string `json:"url"`
+	Detected  bool   `json:"detected"`
+	PortalURL string `json:"portal_url,omitempty"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Path      string `json:"path"`
+}
+
+var metaRefresh = regexp.MustCompile(`(?i)<meta[^>]+http-equiv=["']?refresh["']?[^>]+url=([^"'>\s]+)`)
+
+// DetectCaptivePortal fetches probeURL without following redirects and
+// compares the answer with the expected status and body. Portals intercept
+// plain HTTP and answer with a redirect or their own login page instead.
+// Run it on the physical path; the tunnel cannot pass a portal.
+func (m *Manager) DetectCaptivePortal(probeURL string, expectStatus int, expectBody string) (*PortalResult, error) {
+	result := &PortalResult{URL: probeURL, Path: m.binding.String()}
+
+	client := m.httpClient(10 * time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(probeURL)
+	if err != nil {
+		return result, fmt.Errorf("captive portal probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	result.Status = resp.StatusCode
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		result.Detected = true
+		result.PortalURL = resp.Header.Get("Location")
+		result.Detail = fmt.Sprintf("redirected with HTTP %d", resp.StatusCode)
+	case resp.StatusCode != expectStatus:
+		result.Detected = true
+		result.PortalURL = portalLink(body, probeURL)
+		result.Detail = fmt.Sprintf("HTTP %d instead of %d", resp.StatusCode, expectStatus)
+	case expectBody != "" && strings.TrimSpace(string(body)) != strings.TrimSpace(expectBody):
+		result.Detected = true
+		result.PortalURL = portalLink(body, probeURL)
+		result.Detail = "response content does not match"
+	default:
+		result.Detail = fmt.Sprintf("HTTP %d as expected", resp.StatusCode)
+	}
+
+	return result, nil
+}
+
+// portalLink finds where a portal login page sends the browser, falling
+// back to the probe URL, which a browser will see intercepted too.
+func portalLink(body []byte, probeURL string) string {
+	if match := metaRefresh.FindSubmatch(body); match != nil {
+		return string(match[1])
+	}
+	return probeURL
+}
@@ -0,0 +1,386 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	RouteTableDefault = 253
+	RouteTableMain    = 254
+	RouteTableLocal   = 255
+)
+
+// Netlink constants missing from the syscall package.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+
+	rtmGetRule = 0x22
+	rtmNewRule = 0x20
+	rtmFCloned = 0x200
+
+	fraDst               = 1
+	fraSrc               = 2
+	fraIifname           = 3
+	fraGoto              = 4
+	fraPriority          = 6
+	fraFwmark            = 10
+	fraSuppressPrefixlen = 14
+	fraTable             = 15
+	fraFwmask            = 16
+	fraOifname           = 17
+
+	fibRuleInvert = 0x2
+)
+
+type Route struct {
+	Family  string `json:"family"`
+	Type    string `json:"type"`
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway,omitempty"`
+	Dev     string `json:"dev,omitempty"`
+	Src     string `json:"src,omitempty"`
+	Table   int    `json:"table"`
+	Metric  int    `json:"metric"`
+	Proto   string `json:"proto"`
+	Scope   string `json:"scope"`
+}
+
+func (r Route) IsDefault() bool {
+	return r.Dst == "default"
+}
+
+// Rule is an ip rule. SuppressPrefixlen is -1 when not set.
+type Rule struct {
+	Family            string `json:"family"`
+	Priority          int    `json:"priority"`
+	From              string `json:"from"`
+	To                string `json:"to,omitempty"`
+	IIF               string `json:"iif,omitempty"`
+	OIF               string `json:"oif,omitempty"`
+	FwMark            uint32 `json:"fwmark,omitempty"`
+	FwMask            uint32 `json:"fwmask,omitempty"`
+	Invert            bool   `json:"invert,omitempty"`
+	Table             int    `json:"table,omitempty"`
+	Goto              int    `json:"goto,omitempty"`
+	SuppressPrefixlen int    `json:"suppress_prefixlength"`
+	Action            string `json:"action"`
+}
+
+func (r Rule) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:\t", r.Priority)
+	if r.Invert {
+		b.WriteString("not ")
+	}
+	b.WriteString("from " + r.From)
+	if r.To != "" {
+		b.WriteString(" to " + r.To)
+	}
+	if r.IIF != "" {
+		b.WriteString(" iif " + r.IIF)
+	}
+	if r.OIF != "" {
+		b.WriteString(" oif " + r.OIF)
+	}
+	if r.FwMark != 0 || r.FwMask != 0 {
+		fmt.Fprintf(&b, " fwmark %#x", r.FwMark)
+		if r.FwMask != 0 && r.FwMask != 0xffffffff {
+			fmt.Fprintf(&b, "/%#x", r.FwMask)
+		}
+	}
+	switch r.Action {
+	case "lookup":
+		b.WriteString(" lookup " + TableName(r.Table))
+	case "goto":
+		fmt.Fprintf(&b, " goto %d", r.Goto)
+	default:
+		b.WriteString(" " + r.Action)
+	}
+	if r.SuppressPrefixlen >= 0 {
+		fmt.Fprintf(&b, " suppress_prefixlength %d", r.SuppressPrefixlen)
+	}
+	return b.String()
+}
+
+// ListRoutes dumps the IPv4 and IPv6 routes of every table over netlink.
+func ListRoutes() ([]Route, error) {
+	msgs, err := netlinkDump(syscall.RTM_GETROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump routes: %w", err)
+	}
+
+	var routes []Route
+	for _, msg := range msgs {
+		if msg.Header.Type != syscall.RTM_NEWROUTE || len(msg.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rtm := parseRtMsg(msg.Data)
+		if !isIPFamily(rtm.Family) || rtm.Flags&rtmFCloned != 0 {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+		if err != nil {
+			continue
+		}
+
+		route := Route{
+			Family: familyName(rtm.Family),
+			Type:   routeTypeName(rtm.Type),
+			Dst:    "default",
+			Table:  int(rtm.Table),
+			Proto:  routeProtoName(rtm.Protocol),
+			Scope:  routeScopeName(rtm.Scope),
+		}
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_DST:
+				route.Dst = fmt.Sprintf("%s/%d", net.IP(attr.Value), rtm.Dst_len)
+			case syscall.RTA_GATEWAY:
+				route.Gateway = net.IP(attr.Value).String()
+			case syscall.RTA_PREFSRC:
+				route.Src = net.IP(attr.Value).String()
+			case syscall.RTA_OIF:
+				route.Dev = interfaceName(int(binary.NativeEndian.Uint32(attr.Value)))
+			case syscall.RTA_PRIORITY:
+				route.Metric = int(binary.NativeEndian.Uint32(attr.Value))
+			case syscall.RTA_TABLE:
+				route.Table = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		}
+		routes = append(routes, route)
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Table != routes[j].Table {
+			return routes[i].Table > routes[j].Table
+		}
+		return routes[i].Family < routes[j].Family
+	})
+	return routes, nil
+}
+
+// ListRules dumps the IPv4 and IPv6 policy routing rules over netlink.
+func ListRules() ([]Rule, error) {
+	msgs, err := netlinkDump(rtmGetRule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump rules: %w", err)
+	}
+
+	var rules []Rule
+	for _, msg := range msgs {
+		if msg.Header.Type != rtmNewRule || len(msg.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		// fib_rule_hdr has the same layout as rtmsg, with the action in
+		// the type field.
+		hdr := parseRtMsg(msg.Data)
+		if !isIPFamily(hdr.Family) {
+			continue
+		}
+		msg.Header.Type = syscall.RTM_NEWROUTE
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+		if err != nil {
+			continue
+		}
+
+		rule := Rule{
+			Family:            familyName(hdr.Family),
+			From:              "all",
+			Table:             int(hdr.Table),
+			Invert:            hdr.Flags&fibRuleInvert != 0,
+			SuppressPrefixlen: -1,
+			Action:            ruleActionName(hdr.Type),
+		}
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case fraSrc:
+				rule.From = fmt.Sprintf("%s/%d", net.IP(attr.Value), hdr.Src_len)
+			case fraDst:
+				rule.To = fmt.Sprintf("%s/%d", net.IP(attr.Value), hdr.Dst_len)
+			case fraIifname:
+				rule.IIF = strings.TrimRight(string(attr.Value), "\x00")
+			case fraOifname:
+				rule.OIF = strings.TrimRight(string(attr.Value), "\x00")
+			case fraPriority:
+				rule.Priority = int(binary.NativeEndian.Uint32(attr.Value))
+			case fraFwmark:
+				rule.FwMark = binary.NativeEndian.Uint32(attr.Value)
+			case fraFwmask:
+				rule.FwMask = binary.NativeEndian.Uint32(attr.Value)
+			case fraTable:
+				rule.Table = int(binary.NativeEndian.Uint32(attr.Value))
+			case fraGoto:
+				rule.Goto = int(binary.NativeEndian.Uint32(attr.Value))
+			case fraSuppressPrefixlen:
+				if v := int32(binary.NativeEndian.Uint32(attr.Value)); v >= 0 {
+					rule.SuppressPrefixlen = int(v)
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+	return rules, nil
+}
+
+func netlinkDump(proto int) ([]syscall.NetlinkMessage, error) {
+	data, err := syscall.NetlinkRIB(proto, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	return syscall.ParseNetlinkMessage(data)
+}
+
+func parseRtMsg(b []byte) syscall.RtMsg {
+	return syscall.RtMsg{
+		Family:   b[0],
+		Dst_len:  b[1],
+		Src_len:  b[2],
+		Tos:      b[3],
+		Table:    b[4],
+		Protocol: b[5],
+		Scope:    b[6],
+		Type:     b[7],
+		Flags:    binary.NativeEndian.Uint32(b[8:12]),
+	}
+}
+
+func nativeUint32(b []byte) uint32 {
+	return binary.NativeEndian.Uint32(b)
+}
+
+func interfaceName(index int) string {
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		return ifi.Name
+	}
+	return strconv.Itoa(index)
+}
+
+// isIPFamily filters out the multicast routing families that share the
+// route and rule dumps.
+func isIPFamily(family uint8) bool {
+	return family == syscall.AF_INET || family == syscall.AF_INET6
+}
+
+func familyName(family uint8) string {
+	if family == syscall.AF_INET6 {
+		return "inet6"
+	}
+	return "inet"
+}
+
+// TableName returns the name of a routing table from /etc/iproute2/rt_tables,
+// or its number.
+func TableName(id int) string {
+	switch id {
+	case RouteTableDefault:
+		return "default"
+	case RouteTableMain:
+		return "main"
+	case RouteTableLocal:
+		return "local"
+	}
+
+	for _, path := range []string{"/etc/iproute2/rt_tables", "/usr/share/iproute2/rt_tables"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == strconv.Itoa(id) {
+				file.Close()
+				return fields[1]
+			}
+		}
+		file.Close()
+	}
+	return strconv.Itoa(id)
+}
+
+func routeTypeName(t uint8) string {
+	names := map[uint8]string{
+		syscall.RTN_UNICAST:     "unicast",
+		syscall.RTN_LOCAL:       "local",
+		syscall.RTN_BROADCAST:   "broadcast",
+		syscall.RTN_ANYCAST:     "anycast",
+		syscall.RTN_MULTICAST:   "multicast",
+		syscall.RTN_BLACKHOLE:   "blackhole",
+		syscall.RTN_UNREACHABLE: "unreachable",
+		syscall.RTN_PROHIBIT:    "prohibit",
+		syscall.RTN_THROW:       "throw",
+	}
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+func routeProtoName(p uint8) string {
+	names := map[uint8]string{
+		syscall.RTPROT_UNSPEC:   "unspec",
+		syscall.RTPROT_REDIRECT: "redirect",
+		syscall.RTPROT_KERNEL:   "kernel",
+		syscall.RTPROT_BOOT:     "boot",
+		syscall.RTPROT_STATIC:   "static",
+		syscall.RTPROT_RA:       "ra",
+		syscall.RTPROT_DHCP:     "dhcp",
+		186:                     "bgp",
+		188:                     "ospf",
+	}
+	if name, ok := names[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+func routeScopeName(s uint8) string {
+	switch s {
+	case syscall.RT_SCOPE_UNIVERSE:
+		return "global"
+	case syscall.RT_SCOPE_SITE:
+		return "site"
+	case syscall.RT_SCOPE_LINK:
+		return "link"
+	case syscall.RT_SCOPE_HOST:
+		return "host"
+	case syscall.RT_SCOPE_NOWHERE:
+		return "nowhere"
+	}
+	return strconv.Itoa(int(s))
+}
+
+func ruleActionName(action uint8) string {
+	switch action {
+	case 1:
+		return "lookup"
+	case 2:
+		return "goto"
+	case 3:
+		return "nop"
+	case 6:
+		return "blackhole"
+	case 7:
+		return "unreachable"
+	case 8:
+		return "prohibit"
+	}
+	return strconv.Itoa(int(action))
+}
@@ -0,0 +1,236 @@
+package network
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSpeedTestURL = "https://speed.cloudflare.com"
+
+type SpeedOptions struct {
+	URL           string
+	DownloadBytes int64
+	UploadBytes   int64
+	Rounds        int
+	Timeout       time.Duration
+}
+
+func DefaultSpeedOptions() SpeedOptions {
+	return SpeedOptions{
+		URL:           DefaultSpeedTestURL,
+		DownloadBytes: 25 << 20,
+		UploadBytes:   10 << 20,
+		Rounds:        3,
+		Timeout:       60 * time.Second,
+	}
+}
+
+type SpeedResult struct {
+	Path          string        `json:"path"`
+	URL           string        `json:"url"`
+	DownloadMbps  float64       `json:"download_mbps"`
+	UploadMbps    float64       `json:"upload_mbps"`
+	IdleLatency   time.Duration `json:"idle_latency"`
+	LoadedLatency time.Duration `json:"loaded_latency"`
+	Errors        []string      `json:"errors,omitempty"`
+}
+
+// MeasureThroughput runs download and upload rounds against a speed test
+// endpoint speaking Cloudflare's /__down?bytes=N and /__up protocol, and
+// samples request latency both idle and while the download is running.
+func (m *Manager) MeasureThroughput(opts SpeedOptions) (*SpeedResult, error) {
+	if opts.URL == "" {
+		opts.URL = DefaultSpeedTestURL
+	}
+	if opts.Rounds <= 0 {
+		opts.Rounds = 1
+	}
+	base := strings.TrimSuffix(opts.URL, "/")
+
+	result := &SpeedResult{Path: m.binding.String(), URL: base}
+	client := m.httpClient(opts.Timeout)
+	probeClient := m.httpClient(5 * time.Second)
+
+	idle, err := sampleLatency(probeClient, base, 5, nil)
+	if err != nil {
+		return nil, fmt.Errorf("speed test endpoint unreachable: %w", err)
+	}
+	result.IdleLatency = median(idle)
+
+	done := make(chan struct{})
+	var loaded []time.Duration
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loaded, _ = sampleLatency(probeClient, base, 0, done)
+	}()
+
+	var downloads []float64
+	for i := 0; i < opts.Rounds; i++ {
+		mbps, err := download(client, base, opts.DownloadBytes)
+		if err != nil {
+			result.Errors = append(result.Errors, "download: "+err.Error())
+			continue
+		}
+		downloads = append(downloads, mbps)
+	}
+	close(done)
+	wg.Wait()
+	result.LoadedLatency = median(loaded)
+
+	var uploads []float64
+	for i := 0; i < opts.Rounds; i++ {
+		mbps, err := upload(client, base, opts.UploadBytes)
+		if err != nil {
+			result.Errors = append(result.Errors, "upload: "+err.Error())
+			continue
+		}
+		uploads = append(uploads, mbps)
+	}
+
+	result.DownloadMbps = maxFloat(downloads)
+	result.UploadMbps = maxFloat(uploads)
+
+	if len(downloads) == 0 && len(uploads) == 0 {
+		return result, fmt.Errorf("all throughput rounds failed")
+	}
+	return result, nil
+}
+
+func download(client *http.Client, base string, size int64) (float64, error) {
+	start := time.Now()
+	resp, err := client.Get(fmt.Sprintf("%s/__down?bytes=%d", base, size))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return mbps(n, time.Since(start)), nil
+}
+
+func upload(client *http.Client, base string, size int64) (float64, error) {
+	start := time.Now()
+	resp, err := client.Post(base+"/__up", "application/octet-stream", io.LimitReader(rand.Reader, size))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return mbps(size, time.Since(start)), nil
+}
+
+// sampleLatency times zero-byte downloads, either count of them or until
+// done is closed.
+func sampleLatency(client *http.Client, base string, count int, done <-chan struct{}) ([]time.Duration, error) {
+	var samples []time.Duration
+	var lastErr error
+
+	for i := 0; count == 0 || i < count; i++ {
+		start := time.Now()
+		resp, err := client.Get(base + "/__down?bytes=0")
+		if err != nil {
+			lastErr = err
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			samples = append(samples, time.Since(start))
+		}
+
+		if done != nil {
+			select {
+			case <-done:
+				return samples, lastErr
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, lastErr
+	}
+	return samples, nil
+}
+
+// SpeedTestHandler serves the /__down and /__up endpoints so throughput can
+// be tested against a local server without internet access.
+func SpeedTestHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/__down", func(w http.ResponseWriter, r *http.Request) {
+		size, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid bytes parameter", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+		chunk := make([]byte, 64*1024)
+		for size > 0 {
+			n := int64(len(chunk))
+			if size < n {
+				n = size
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return
+			}
+			size -= n
+		}
+	})
+
+	mux.HandleFunc("/__up", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+	})
+
+	return mux
+}
+
+func mbps(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / elapsed.Seconds() / 1e6
+}
+
+func median(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+func maxFloat(values []float64) float64 {
+	best := 0.0
+	for _, v := range values {
+		if v > best {
+			best = v
+		}
+	}
+	return best
+}
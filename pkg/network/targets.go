@@ -0,0 +1,221 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"darp/pkg/dns"
+)
+
+// Target is a named endpoint probed by the connectivity and latency tests.
+// Expect is "reachable" (the default), "unreachable", or an HTTP status code
+// for http targets.
+type Target struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`
+	Expect   string `json:"expect,omitempty"`
+}
+
+func DefaultTargets() []Target {
+	return []Target{
+		{Name: "Cloudflare DNS (1.1.1.1)", Host: "1.1.1.1", Protocol: "icmp"},
+		{Name: "Cloudflare DNS (1.0.0.1)", Host: "1.0.0.1", Protocol: "icmp"},
+		{Name: "Google DNS (8.8.8.8)", Host: "8.8.8.8", Protocol: "icmp"},
+		{Name: "Google DNS (8.8.4.4)", Host: "8.8.4.4", Protocol: "icmp"},
+		{Name: "Cloudflare HTTP", Host: "1.1.1.1", Port: 80, Protocol: "tcp"},
+		{Name: "Cloudflare DNS (2606:4700:4700::1111)", Host: "2606:4700:4700::1111", Protocol: "icmp"},
+		{Name: "Cloudflare DNS (2606:4700:4700::1001)", Host: "2606:4700:4700::1001", Protocol: "icmp"},
+		{Name: "Google DNS (2001:4860:4860::8888)", Host: "2001:4860:4860::8888", Protocol: "icmp"},
+		{Name: "Google DNS (2001:4860:4860::8844)", Host: "2001:4860:4860::8844", Protocol: "icmp"},
+		{Name: "Cloudflare HTTP (IPv6)", Host: "2606:4700:4700::1111", Port: 80, Protocol: "tcp"},
+	}
+}
+
+func (t Target) Label() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Host
+}
+
+func (t Target) expectsReachable() bool {
+	return t.Expect != "unreachable"
+}
+
+func (t Target) expectsStatus() bool {
+	return t.Protocol == "http" && t.Expect != "" && t.Expect != "reachable" && t.Expect != "unreachable"
+}
+
+// Family returns the address family of a literal IP host, "4" or "6", or
+// an empty string for host names and URLs.
+func (t Target) Family() string {
+	host := t.Host
+	if u, err := url.Parse(t.Host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "4"
+	}
+	return "6"
+}
+
+func (m *Manager) SetTargets(targets []Target) {
+	m.targets = targets
+}
+
+// SetFamily restricts tests to IPv4 ("4") or IPv6 ("6"): targets and DNS
+// servers of the other family are skipped and host names are resolved and
+// dialed in the chosen family only. An empty family tests both.
+func (m *Manager) SetFamily(family string) {
+	m.family = family
+}
+
+func (m *Manager) Family() string {
+	return m.family
+}
+
+// Targets returns the targets of the selected family.
+func (m *Manager) Targets() []Target {
+	targets := m.targets
+	if len(targets) == 0 {
+		targets = DefaultTargets()
+	}
+	if m.family == "" {
+		return targets
+	}
+
+	var filtered []Target
+	for _, target := range targets {
+		if family := target.Family(); family == "" || family == m.family {
+			filtered = append(filtered, target)
+		}
+	}
+	return filtered
+}
+
+// familyNetwork narrows a Go network name such as "tcp" or "ip" to the
+// selected family.
+func (m *Manager) familyNetwork(network string) string {
+	if m.family == "" || strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+		return network
+	}
+	return network + m.family
+}
+
+// CheckTarget probes a single target and compares the outcome with the
+// target's expected result.
+func (m *Manager) CheckTarget(t Target) CheckResult {
+	result := CheckResult{Name: t.Label()}
+	detail, err := m.probeOnce(t)
+
+	switch {
+	case !t.expectsReachable():
+		result.Passed = err != nil
+		result.Detail = "unreachable as expected"
+		if err == nil {
+			result.Detail = "reachable, expected unreachable"
+		}
+	case err != nil:
+		result.Detail = err.Error()
+	case t.expectsStatus() && detail != t.Expect:
+		result.Detail = fmt.Sprintf("got status %s, expected %s", detail, t.Expect)
+	default:
+		result.Passed = true
+		result.Detail = detail
+	}
+
+	return result
+}
+
+func (m *Manager) probeOnce(t Target) (string, error) {
+	timeout := 5 * time.Second
+
+	switch t.Protocol {
+	case "icmp":
+		addr, err := net.ResolveIPAddr(m.familyNetwork("ip"), t.Host)
+		if err != nil {
+			return "", err
+		}
+		conn, err := m.openICMP(addr.IP)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		rtt, err := conn.echo(0, timeout)
+		if err != nil {
+			return "", err
+		}
+		return rtt.Round(time.Microsecond).String(), nil
+
+	case "tcp":
+		rtt, err := m.tcpProbe(net.JoinHostPort(t.Host, strconv.Itoa(t.Port)), timeout)
+		if err != nil {
+			return "", err
+		}
+		return rtt.Round(time.Microsecond).String(), nil
+
+	case "udp":
+		return m.udpProbe(net.JoinHostPort(t.Host, strconv.Itoa(t.Port)), timeout)
+
+	case "http":
+		url := t.Host
+		if !strings.Contains(url, "://") {
+			url = "http://" + url
+		}
+		resp, err := m.httpClient(timeout).Get(url)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if !t.expectsStatus() && resp.StatusCode >= http.StatusBadRequest {
+			return "", fmt.Errorf("got status %d", resp.StatusCode)
+		}
+		return strconv.Itoa(resp.StatusCode), nil
+	}
+
+	return "", fmt.Errorf("unsupported protocol %q", t.Protocol)
+}
+
+// udpProbe treats any reply as reachable. Port 53 gets a real DNS query so
+// resolvers answer; other services may legitimately stay silent, which is
+// reported as unreachable.
+func (m *Manager) udpProbe(endpoint string, timeout time.Duration) (string, error) {
+	conn, err := m.dialer("udp", timeout).Dial(m.familyNetwork("udp"), endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	payload := []byte{0}
+	if _, port, _ := net.SplitHostPort(endpoint); port == "53" {
+		payload, _ = dns.NewQuery(uint16(time.Now().UnixNano()), "", dns.TypeA)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 1500)
+	if _, err := conn.Read(buf); err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return "", fmt.Errorf("port unreachable")
+		}
+		return "", fmt.Errorf("no reply: %w", err)
+	}
+	return time.Since(start).Round(time.Microsecond).String(), nil
+}
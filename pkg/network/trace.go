@@ -0,0 +1,87 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultTraceURL = "https://www.cloudflare.com/cdn-cgi/trace"
+
+type TraceResult struct {
+	URL    string            `json:"url"`
+	Warp   string            `json:"warp"`
+	IP     string            `json:"ip"`
+	Colo   string            `json:"colo"`
+	Loc    string            `json:"loc"`
+	Fields map[string]string `json:"fields"`
+}
+
+// ViaWARP reports whether the trace endpoint saw the request arrive through
+// WARP; "plus" is reported for WARP+ accounts.
+func (t *TraceResult) ViaWARP() bool {
+	return t.Warp == "on" || t.Warp == "plus"
+}
+
+func (m *Manager) Trace(traceURL string) (*TraceResult, error) {
+	if traceURL == "" {
+		traceURL = DefaultTraceURL
+	}
+
+	resp, err := m.httpClient(10 * time.Second).Get(traceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trace: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("trace endpoint returned %s", resp.Status)
+	}
+
+	fields, err := parseTrace(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+
+	return &TraceResult{
+		URL:    traceURL,
+		Warp:   fields["warp"],
+		IP:     fields["ip"],
+		Colo:   fields["colo"],
+		Loc:    fields["loc"],
+		Fields: fields,
+	}, nil
+}
+
+// VerifyTunnel fails unless the trace endpoint reports traffic egressing via
+// WARP.
+func (m *Manager) VerifyTunnel(traceURL string) (*TraceResult, error) {
+	trace, err := m.Trace(traceURL)
+	if err != nil {
+		return nil, err
+	}
+	if !trace.ViaWARP() {
+		warp := trace.Warp
+		if warp == "" {
+			warp = "unknown"
+		}
+		return trace, fmt.Errorf("traffic is not egressing via WARP (warp=%s, ip=%s)", warp, trace.IP)
+	}
+	return trace, nil
+}
+
+func parseTrace(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || key == "" {
+			continue
+		}
+		fields[key] = value
+	}
+	return fields, scanner.Err()
+}
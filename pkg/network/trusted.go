@@ -0,0 +1,179 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// NetworkIdentity describes the physical network the default route uses.
+type NetworkIdentity struct {
+	Interface     string   `json:"interface"`
+	Gateway       string   `json:"gateway"`
+	GatewayMAC    string   `json:"gateway_mac,omitempty"`
+	Subnets       []string `json:"subnets"`
+	SearchDomains []string `json:"search_domains,omitempty"`
+}
+
+// TrustedRule matches a network when every non-empty field matches.
+type TrustedRule struct {
+	Name         string
+	GatewayMAC   string
+	Subnet       string
+	Interface    string
+	SearchDomain string
+}
+
+func (r TrustedRule) Matches(id *NetworkIdentity) bool {
+	if r.GatewayMAC != "" && !strings.EqualFold(r.GatewayMAC, id.GatewayMAC) {
+		return false
+	}
+	if r.Interface != "" && !ifaceMatches(r.Interface, id.Interface) {
+		return false
+	}
+	if r.Subnet != "" {
+		_, want, err := net.ParseCIDR(r.Subnet)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, subnet := range id.Subnets {
+			_, have, err := net.ParseCIDR(subnet)
+			if err == nil && have.String() == want.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.SearchDomain != "" {
+		found := false
+		for _, domain := range id.SearchDomains {
+			if strings.EqualFold(strings.TrimSuffix(domain, "."), strings.TrimSuffix(r.SearchDomain, ".")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTrusted returns the first rule matching the network.
+func MatchTrusted(rules []TrustedRule, id *NetworkIdentity) (TrustedRule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(id) {
+			return rule, true
+		}
+	}
+	return TrustedRule{}, false
+}
+
+// CurrentNetwork identifies the physical network from the main table's IPv4
+// default route, ignoring the tunnel interface.
+func (m *Manager) CurrentNetwork() (*NetworkIdentity, error) {
+	routes, err := ListRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	var gateway Route
+	found := false
+	for _, route := range routes {
+		if route.Table != RouteTableMain || route.Family != "inet" || !route.IsDefault() || route.Dev == m.interfaceName {
+			continue
+		}
+		if !found || route.Metric < gateway.Metric {
+			gateway, found = route, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no default route found")
+	}
+
+	id := &NetworkIdentity{Interface: gateway.Dev, Gateway: gateway.Gateway}
+	if ifi, err := net.InterfaceByName(gateway.Dev); err == nil {
+		if addrs, err := ifi.Addrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok {
+					id.Subnets = append(id.Subnets, (&net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}).String())
+				}
+			}
+		}
+	}
+	if gateway.Gateway != "" {
+		id.GatewayMAC = neighborMAC(gateway.Gateway, gateway.Dev)
+	}
+	id.SearchDomains = searchDomains(gateway.Dev)
+
+	return id, nil
+}
+
+// neighborMAC looks the gateway up in the ARP table, sending it a packet
+// first when it is not there yet.
+func neighborMAC(gateway, dev string) string {
+	if mac := arpLookup(gateway, dev); mac != "" {
+		return mac
+	}
+	if conn, err := net.DialTimeout("udp", net.JoinHostPort(gateway, "9"), time.Second); err == nil {
+		conn.Write([]byte{0})
+		conn.Close()
+		time.Sleep(300 * time.Millisecond)
+	}
+	return arpLookup(gateway, dev)
+}
+
+func arpLookup(ip, dev string) string {
+	file, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 6 && fields[0] == ip && fields[5] == dev && fields[3] != "00:00:00:00:00:00" {
+			return fields[3]
+		}
+	}
+	return ""
+}
+
+// searchDomains prefers the link's domains from systemd-resolved, since
+// resolv.conf is rewritten for the tunnel once it is up.
+func searchDomains(dev string) []string {
+	if output, err := exec.Command("resolvectl", "domain", dev).Output(); err == nil {
+		if _, list, ok := strings.Cut(string(output), ":"); ok {
+			var domains []string
+			for _, domain := range strings.Fields(list) {
+				domains = append(domains, strings.TrimPrefix(domain, "~"))
+			}
+			return domains
+		}
+	}
+
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && (fields[0] == "search" || fields[0] == "domain") {
+			domains = append(domains, fields[1:]...)
+		}
+	}
+	return domains
+}
@@ -0,0 +1,59 @@
+package network
+
+import "testing"
+
+func TestTrustedRuleMatches(t *testing.T) {
+	home := &NetworkIdentity{
+		Interface:     "wlan0",
+		Gateway:       "192.168.1.1",
+		GatewayMAC:    "aa:bb:cc:dd:ee:ff",
+		Subnets:       []string{"192.168.1.0/24", "fd00:1::/64"},
+		SearchDomains: []string{"home.lan."},
+	}
+
+	tests := []struct {
+		name string
+		rule TrustedRule
+		want bool
+	}{
+		{"empty rule", TrustedRule{Name: "any"}, true},
+		{"gateway mac", TrustedRule{GatewayMAC: "AA:BB:CC:DD:EE:FF"}, true},
+		{"other gateway mac", TrustedRule{GatewayMAC: "aa:bb:cc:dd:ee:00"}, false},
+		{"interface", TrustedRule{Interface: "wlan0"}, true},
+		{"interface wildcard", TrustedRule{Interface: "wl+"}, true},
+		{"other interface", TrustedRule{Interface: "eth0"}, false},
+		{"subnet", TrustedRule{Subnet: "192.168.1.0/24"}, true},
+		{"subnet host address", TrustedRule{Subnet: "192.168.1.77/24"}, true},
+		{"ipv6 subnet", TrustedRule{Subnet: "fd00:1::/64"}, true},
+		{"other prefix length", TrustedRule{Subnet: "192.168.0.0/16"}, false},
+		{"invalid subnet", TrustedRule{Subnet: "192.168.1.0"}, false},
+		{"search domain", TrustedRule{SearchDomain: "HOME.lan"}, true},
+		{"other search domain", TrustedRule{SearchDomain: "corp.example"}, false},
+		{"all fields", TrustedRule{GatewayMAC: "aa:bb:cc:dd:ee:ff", Subnet: "192.168.1.0/24", Interface: "wlan0", SearchDomain: "home.lan"}, true},
+		{"one field off", TrustedRule{GatewayMAC: "aa:bb:cc:dd:ee:ff", Subnet: "10.0.0.0/8"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(home); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTrusted(t *testing.T) {
+	id := &NetworkIdentity{Interface: "eth0", GatewayMAC: "aa:bb:cc:dd:ee:ff"}
+	rules := []TrustedRule{
+		{Name: "office", Interface: "wlan0"},
+		{Name: "home", GatewayMAC: "aa:bb:cc:dd:ee:ff"},
+		{Name: "wired", Interface: "eth+"},
+	}
+
+	rule, ok := MatchTrusted(rules, id)
+	if !ok || rule.Name != "home" {
+		t.Errorf("MatchTrusted = %q, %v; want home", rule.Name, ok)
+	}
+	if _, ok := MatchTrusted(rules[:1], id); ok {
+		t.Error("MatchTrusted matched an unrelated rule")
+	}
+}
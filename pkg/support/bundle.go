@@ -0,0 +1,226 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"darp/pkg/config"
+	"darp/pkg/doctor"
+)
+
+// WireGuard keys are 32 bytes of base64, always 44 characters ending in "=".
+var keyPattern = regexp.MustCompile(`[A-Za-z0-9+/]{43}=`)
+
+const redacted = "REDACTED"
+
+type item struct {
+	name    string
+	collect func() ([]byte, error)
+}
+
+type Bundle struct {
+	config   *config.Config
+	version  string
+	warnings []string
+}
+
+func NewBundle(cfg *config.Config, version string) *Bundle {
+	return &Bundle{config: cfg, version: version}
+}
+
+// Write collects every item into a gzipped tarball at path. Items that
+// cannot be collected are noted in the manifest instead of failing the
+// bundle, since broken systems are exactly the ones we need bundles from.
+func (b *Bundle) Write(path string) ([]string, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	now := time.Now()
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "darp %s support bundle\ncreated: %s\n\n", b.version, now.Format(time.RFC3339))
+
+	for _, it := range b.items() {
+		data, err := it.collect()
+		if err != nil {
+			b.warnings = append(b.warnings, fmt.Sprintf("%s: %v", it.name, err))
+			fmt.Fprintf(&manifest, "%-28s not collected: %v\n", it.name, err)
+			if len(data) == 0 {
+				continue
+			}
+		} else {
+			fmt.Fprintf(&manifest, "%-28s %d bytes\n", it.name, len(data))
+		}
+
+		if err := writeFile(tw, it.name, data, now); err != nil {
+			return b.warnings, err
+		}
+	}
+
+	if err := writeFile(tw, "MANIFEST.txt", []byte(manifest.String()), now); err != nil {
+		return b.warnings, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return b.warnings, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return b.warnings, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	return b.warnings, nil
+}
+
+func (b *Bundle) items() []item {
+	wgConf := b.config.Network.Interface + ".conf"
+	items := []item{
+		{"config.json", b.redactedConfig},
+		{"wireguard/" + wgConf, readRedacted(filepath.Join("/etc/wireguard", wgConf))},
+		{"wireguard/wg-show.txt", runRedacted("wg", "show", "all")},
+		{"state/dns-stats.json", readFile(b.config.DNSStub.StatsFile)},
+		{"state/sysctl-snapshot.json", readFile(b.config.Optimize.SnapshotFile)},
+		{"state/routing-snapshot.json", readFile(b.config.Network.Routing.SnapshotFile)},
+		{"logs/journal.txt", run("journalctl", "-u", "darp", "-n", "1000", "--no-pager")},
+	}
+	// Logs written to a file never reach the journal.
+	if output := b.config.Logging.Output; output != "" && output != "stdout" && output != "stderr" {
+		items = append(items, item{"logs/darp.log", tailFile(output, 1000)})
+	}
+	return append(items, []item{
+		{"network/ip-addr.txt", run("ip", "addr", "show")},
+		{"network/ip-route.txt", run("ip", "route", "show", "table", "all")},
+		{"network/ip6-route.txt", run("ip", "-6", "route", "show", "table", "all")},
+		{"network/ip-rule.txt", run("ip", "rule", "show")},
+		{"network/ip6-rule.txt", run("ip", "-6", "rule", "show")},
+		{"firewall/nft-ruleset.txt", run("nft", "list", "ruleset")},
+		{"firewall/iptables-save.txt", run("iptables-save")},
+		{"resolver/resolv.conf", readFile("/etc/resolv.conf")},
+		{"resolver/resolvectl.txt", run("resolvectl", "status")},
+		{"doctor.json", b.doctorReport},
+	}...)
+}
+
+func (b *Bundle) redactedConfig() ([]byte, error) {
+	data, err := json.Marshal(b.config)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	redactTree(tree)
+
+	return json.MarshalIndent(tree, "", "  ")
+}
+
+func (b *Bundle) doctorReport() ([]byte, error) {
+	return json.MarshalIndent(doctor.New(b.config).Run(), "", "  ")
+}
+
+// redactTree blanks every value whose key suggests a secret.
+func redactTree(tree map[string]interface{}) {
+	for key, value := range tree {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "key") || strings.Contains(lower, "token") ||
+			strings.Contains(lower, "secret") || strings.Contains(lower, "password") {
+			tree[key] = redacted
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redactTree(v)
+		case []interface{}:
+			for _, elem := range v {
+				if m, ok := elem.(map[string]interface{}); ok {
+					redactTree(m)
+				}
+			}
+		}
+	}
+}
+
+func redactKeys(data []byte) []byte {
+	return keyPattern.ReplaceAll(data, []byte(redacted))
+}
+
+func readFile(path string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// tailFile reads the last lines of a file, like the journal's -n.
+func tailFile(path string, lines int) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		end := len(data)
+		if end > 0 && data[end-1] == '\n' {
+			end--
+		}
+		for i := end - 1; i >= 0; i-- {
+			if data[i] == '\n' {
+				if lines--; lines == 0 {
+					return data[i+1:], nil
+				}
+			}
+		}
+		return data, nil
+	}
+}
+
+func readRedacted(path string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		data, err := os.ReadFile(path)
+		return redactKeys(data), err
+	}
+}
+
+func run(name string, args ...string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if _, err := exec.LookPath(name); err != nil {
+			return nil, fmt.Errorf("%s not installed", name)
+		}
+		return exec.Command(name, args...).CombinedOutput()
+	}
+}
+
+func runRedacted(name string, args ...string) func() ([]byte, error) {
+	collect := run(name, args...)
+	return func() ([]byte, error) {
+		data, err := collect()
+		return redactKeys(data), err
+	}
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    "darp-support/" + name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package support
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTailFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   int
+		want    string
+	}{
+		{"fewer lines", "a\nb\n", 5, "a\nb\n"},
+		{"exact", "a\nb\n", 2, "a\nb\n"},
+		{"tail", "a\nb\nc\nd\n", 2, "c\nd\n"},
+		{"no trailing newline", "a\nb\nc", 2, "b\nc"},
+		{"empty", "", 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "darp.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tailFile(path, tt.lines)()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("tailFile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tailFile(filepath.Join(t.TempDir(), "missing.log"), 1)(); err == nil {
+		t.Error("missing file read without error")
+	}
+}
@@ -1,16 +1,39 @@
 package warp
 
 import (
+	"bytes"
+	"crypto/ecdh"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// DefaultPeerPublicKey is the public key of the WARP servers, used when a
+// registration does not name one.
+const DefaultPeerPublicKey = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+
+// Client hands out WARP configurations using the local endpoint, MTU and
+// DNS settings. The device is registered with the WARP API at APIURL once
+// and the registration is kept in RegistrationFile; without a file every
+// configuration registers a new device.
 type Client struct {
+	Endpoint         string
+	MTU              int
+	DNS              []string
+	APIURL           string
+	RegistrationFile string
 }
 
-func NewClient() *Client {
-	return &Client{}
+func NewClient(endpoint string, mtu int, dns []string) *Client {
+	return &Client{Endpoint: endpoint, MTU: mtu, DNS: dns}
 }
 
 type Config struct {
@@ -19,35 +42,57 @@ type Config struct {
 	MTU       int       `json:"mtu"`
 }
 
+// PersistentKeepalive is in seconds; zero disables it.
 type Peer struct {
-	PublicKey  string   `json:"public_key"`
-	Endpoint   string   `json:"endpoint"`
-	AllowedIPs []string `json:"allowed_ips"`
+	PublicKey           string   `json:"public_key"`
+	Endpoint            string   `json:"endpoint"`
+	AllowedIPs          []string `json:"allowed_ips"`
+	PersistentKeepalive int      `json:"persistent_keepalive,omitempty"`
 }
 
+// A zero ListenPort lets WireGuard pick one; a zero FwMark leaves its
+// packets unmarked.
 type Interface struct {
 	PrivateKey string   `json:"private_key"`
 	Addresses  []string `json:"addresses"`
 	DNS        []string `json:"dns"`
+	ListenPort int      `json:"listen_port,omitempty"`
+	FwMark     uint32   `json:"fwmark,omitempty"`
+}
+
+// Registration is what the WARP API assigned to this device. IPv6 is empty
+// when no IPv6 address was assigned.
+type Registration struct {
+	ID            string `json:"id"`
+	Token         string `json:"token"`
+	PrivateKey    string `json:"private_key"`
+	PeerPublicKey string `json:"peer_public_key"`
+	IPv4          string `json:"ipv4"`
+	IPv6          string `json:"ipv6,omitempty"`
 }
 
 func (c *Client) GetWARPConfig() (*Config, error) {
-	privateKey, err := c.generatePrivateKey()
+	reg, err := c.Registration()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
+		return nil, err
+	}
+
+	addresses := []string{reg.IPv4 + "/32"}
+	if reg.IPv6 != "" {
+		addresses = append(addresses, reg.IPv6+"/128")
 	}
 
 	config := &Config{
-		MTU: 1280,
+		MTU: c.MTU,
 		Interface: Interface{
-			PrivateKey: privateKey,
-			Addresses:  []string{"172.16.0.2/32"},
-			DNS:        []string{"1.1.1.1", "1.0.0.1"},
+			PrivateKey: reg.PrivateKey,
+			Addresses:  addresses,
+			DNS:        c.DNS,
 		},
 		Peers: []Peer{
 			{
-				PublicKey:  "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
-				Endpoint:   "engage.cloudflareclient.com:2408",
+				PublicKey:  reg.PeerPublicKey,
+				Endpoint:   c.Endpoint,
 				AllowedIPs: []string{"0.0.0.0/0", "::/0"},
 			},
 		},
@@ -56,11 +101,131 @@ func (c *Client) GetWARPConfig() (*Config, error) {
 	return config, nil
 }
 
-func (c *Client) generatePrivateKey() (string, error) {
-	privateKey := make([]byte, 32)
-	if _, err := rand.Read(privateKey); err != nil {
-		return "", err
+// Registration returns the saved registration, registering the device
+// first when there is none.
+func (c *Client) Registration() (*Registration, error) {
+	if c.RegistrationFile != "" {
+		data, err := os.ReadFile(c.RegistrationFile)
+		if err == nil {
+			var reg Registration
+			if err := json.Unmarshal(data, &reg); err != nil {
+				return nil, fmt.Errorf("failed to parse registration: %w", err)
+			}
+			return &reg, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read registration: %w", err)
+		}
+	}
+
+	reg, err := c.register()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.RegistrationFile != "" {
+		data, err := json.MarshalIndent(reg, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal registration: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(c.RegistrationFile), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create registration directory: %w", err)
+		}
+		if err := os.WriteFile(c.RegistrationFile, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save registration: %w", err)
+		}
+	}
+	return reg, nil
+}
+
+type registerRequest struct {
+	Key       string `json:"key"`
+	InstallID string `json:"install_id"`
+	FCMToken  string `json:"fcm_token"`
+	TOS       string `json:"tos"`
+	Model     string `json:"model"`
+	Locale    string `json:"locale"`
+}
+
+type registerResponse struct {
+	ID     string `json:"id"`
+	Token  string `json:"token"`
+	Config struct {
+		Peers []struct {
+			PublicKey string `json:"public_key"`
+		} `json:"peers"`
+		Interface struct {
+			Addresses struct {
+				V4 string `json:"v4"`
+				V6 string `json:"v6"`
+			} `json:"addresses"`
+		} `json:"interface"`
+	} `json:"config"`
+}
+
+// register creates a key pair and registers its public key with the WARP
+// API, which answers with the tunnel addresses of the new device.
+func (c *Client) register() (*Registration, error) {
+	if c.APIURL == "" {
+		return nil, fmt.Errorf("no WARP API URL configured")
+	}
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	body, err := json.Marshal(registerRequest{
+		Key:    base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes()),
+		TOS:    time.Now().UTC().Format(time.RFC3339),
+		Model:  "PC",
+		Locale: "en_US",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal registration request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.APIURL, "/")+"/reg", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registration request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "okhttp/3.12.1")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register with WARP: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to register with WARP: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var answer registerResponse
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return nil, fmt.Errorf("failed to parse registration response: %w", err)
 	}
 
-	return base64.StdEncoding.EncodeToString(privateKey), nil
+	addresses := answer.Config.Interface.Addresses
+	if ip := net.ParseIP(addresses.V4); ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("registration response has no IPv4 address")
+	}
+
+	reg := &Registration{
+		ID:            answer.ID,
+		Token:         answer.Token,
+		PrivateKey:    base64.StdEncoding.EncodeToString(privateKey.Bytes()),
+		PeerPublicKey: DefaultPeerPublicKey,
+		IPv4:          addresses.V4,
+	}
+	if ip := net.ParseIP(addresses.V6); ip != nil && ip.To4() == nil {
+		reg.IPv6 = addresses.V6
+	}
+	if len(answer.Config.Peers) > 0 && answer.Config.Peers[0].PublicKey != "" {
+		reg.PeerPublicKey = answer.Config.Peers[0].PublicKey
+	}
+	return reg, nil
 }
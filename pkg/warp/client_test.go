@@ -0,0 +1,92 @@
+package warp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// registrationServer answers registrations with the given IPv6 address,
+// none when it is empty, and counts them.
+func registrationServer(t *testing.T, ipv6 string, count *int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req registerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if key, err := base64.StdEncoding.DecodeString(req.Key); err != nil || len(key) != 32 {
+			http.Error(w, "bad key", http.StatusBadRequest)
+			return
+		}
+		*count++
+
+		var answer registerResponse
+		answer.ID = "device"
+		answer.Config.Interface.Addresses.V4 = "172.16.0.2"
+		answer.Config.Interface.Addresses.V6 = ipv6
+		json.NewEncoder(w).Encode(answer)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetWARPConfigAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		ipv6 string
+		want []string
+	}{
+		{"assigned", "2606:4700:110:8001::2", []string{"172.16.0.2/32", "2606:4700:110:8001::2/128"}},
+		{"none", "", []string{"172.16.0.2/32"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := 0
+			client := NewClient("198.51.100.1:2408", 1280, []string{"1.1.1.1"})
+			client.APIURL = registrationServer(t, tt.ipv6, &count).URL
+			client.RegistrationFile = filepath.Join(t.TempDir(), "registration.json")
+
+			config, err := client.GetWARPConfig()
+			if err != nil {
+				t.Fatalf("GetWARPConfig: %v", err)
+			}
+			if !reflect.DeepEqual(config.Interface.Addresses, tt.want) {
+				t.Errorf("addresses = %v, want %v", config.Interface.Addresses, tt.want)
+			}
+			if got := carriesIPv6(config); got != (tt.ipv6 != "") {
+				t.Errorf("carriesIPv6 = %v", got)
+			}
+			if config.Peers[0].PublicKey != DefaultPeerPublicKey {
+				t.Errorf("peer key = %q, want the default", config.Peers[0].PublicKey)
+			}
+		})
+	}
+}
+
+func TestRegistrationIsReused(t *testing.T) {
+	count := 0
+	client := NewClient("198.51.100.1:2408", 1280, nil)
+	client.APIURL = registrationServer(t, "2606:4700:110:8001::2", &count).URL
+	client.RegistrationFile = filepath.Join(t.TempDir(), "registration.json")
+
+	first, err := client.GetWARPConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.GetWARPConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("registered %d times, want once", count)
+	}
+	if first.Interface.PrivateKey != second.Interface.PrivateKey {
+		t.Error("private key changed between configurations")
+	}
+}
@@ -3,28 +3,123 @@ package warp
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"darp/pkg/network"
 )
 
 type Manager struct {
-	client    *Client
-	config    *Config
-	isConnected bool
+	client        *Client
+	config        *Config
+	isConnected   bool
 	interfaceName string
+	routing       *network.PolicyRouting
+	apps          *network.AppTunnel
+	namespace     *network.Namespace
+	ipv6          string
+	endpointV6    string
+	ipv6Guard     *network.IPv6Guard
+	listenPort    int
+	fwmark        uint32
+	keepalive     int
+	autoKeepalive bool
+	traceURL      string
+	timeout       time.Duration
 }
 
-func NewManager(client *Client, config *Config) *Manager {
+// DefaultNATKeepalive is the PersistentKeepalive used behind NAT when none
+// is configured.
+const DefaultNATKeepalive = 25
+
+// NewManager manages the WireGuard interface interfaceName. wg-quick names
+// the interface after its configuration file, so that is
+// /etc/wireguard/<interfaceName>.conf.
+func NewManager(client *Client, interfaceName string) *Manager {
 	return &Manager{
-		client:    client,
-		config:    config,
-		interfaceName: "warp0",
+		client:        client,
+		interfaceName: interfaceName,
+		ipv6:          "enable",
 	}
 }
 
-func (m *Manager) Connect() error {
+func (m *Manager) Client() *Client {
+	return m.client
+}
+
+func (m *Manager) InterfaceName() string {
+	return m.interfaceName
+}
+
+func (m *Manager) Timeout() time.Duration {
+	return m.timeout
+}
+
+// SetTimeout makes Connect fail when no handshake completes within timeout.
+// Zero does not wait.
+func (m *Manager) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// SetIPv6 selects how IPv6 is handled: "enable" tunnels it, "disable"
+// leaves it on the physical network and "block" routes it into the tunnel
+// without an address so it fails instead of leaking. endpointV6 is used
+// instead of the peer's endpoint on IPv6-only networks.
+func (m *Manager) SetIPv6(mode, endpointV6 string) {
+	m.ipv6 = mode
+	m.endpointV6 = endpointV6
+}
+
+// SetListenPort fixes WireGuard's UDP port; zero picks a random one.
+func (m *Manager) SetListenPort(port int) {
+	m.listenPort = port
+}
+
+// SetFwMark sets the mark WireGuard puts on its own packets. Policy routing
+// overrides it with its own mark.
+func (m *Manager) SetFwMark(mark uint32) {
+	m.fwmark = mark
+}
+
+// SetKeepalive sets PersistentKeepalive for every peer. With auto and no
+// interval, DefaultNATKeepalive is used when the physical network is found
+// to be behind NAT, checked against the trace endpoint at traceURL.
+func (m *Manager) SetKeepalive(seconds int, auto bool, traceURL string) {
+	m.keepalive = seconds
+	m.autoKeepalive = auto
+	m.traceURL = traceURL
+}
+
+// SetIPv6Guard blocks native IPv6 while connected whenever the tunnel does
+// not carry IPv6, unless IPv6 is deliberately left outside with "disable".
+func (m *Manager) SetIPv6Guard(guard *network.IPv6Guard) {
+	m.ipv6Guard = guard
+}
+
+// SetPolicyRouting makes the manager route through a dedicated table with
+// fwmark rules instead of letting wg-quick replace the default route.
+func (m *Manager) SetPolicyRouting(routing *network.PolicyRouting) {
+	m.routing = routing
+}
+
+// SetAppTunnel limits the tunnel to the applications selected by apps. The
+// policy routing must use the apps mark.
+func (m *Manager) SetAppTunnel(apps *network.AppTunnel) {
+	m.apps = apps
+}
+
+// SetNamespace isolates the tunnel in a network namespace: the interface is
+// created here and moved into the namespace, and wg-quick is not used.
+func (m *Manager) SetNamespace(namespace *network.Namespace) {
+	m.namespace = namespace
+}
+
+func (m *Manager) Connect() (err error) {
 	log.Println("Connecting to Cloudflare WARP...")
 
 	config, err := m.client.GetWARPConfig()
@@ -32,29 +127,169 @@ func (m *Manager) Connect() error {
 		return fmt.Errorf("failed to get WARP configuration: %w", err)
 	}
 
+	m.applyIPv6(config)
+	m.applyWireGuard(config)
 	m.config = config
 
+	// Every step that changes the system registers its undo. When a later
+	// step fails they run in reverse order, leaving nothing behind.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if uerr := undo[i](); uerr != nil {
+				log.Printf("Warning: %v", uerr)
+			}
+		}
+	}()
+
 	if err := m.createWireGuardConfig(config); err != nil {
 		return fmt.Errorf("failed to create WireGuard config: %w", err)
 	}
+	undo = append(undo, m.cleanupConfig)
+
+	if m.namespace != nil {
+		if err := m.startNamespacedInterface(config); err != nil {
+			return fmt.Errorf("failed to start WireGuard interface in namespace: %w", err)
+		}
+		undo = append(undo, m.namespace.Delete)
+
+		if err := m.waitForHandshake(); err != nil {
+			return err
+		}
+		m.isConnected = true
+		log.Printf("Connected to Cloudflare WARP in network namespace %s", m.namespace.Name)
+		return nil
+	}
 
 	if err := m.startWireGuardInterface(); err != nil {
 		return fmt.Errorf("failed to start WireGuard interface: %w", err)
 	}
+	undo = append(undo, m.stopWireGuardInterface)
+
+	if m.routing != nil {
+		var allowedIPs []string
+		for _, peer := range config.Peers {
+			allowedIPs = append(allowedIPs, peer.AllowedIPs...)
+		}
+		if err := m.routing.Install(allowedIPs); err != nil {
+			return fmt.Errorf("failed to install policy routing: %w", err)
+		}
+		undo = append(undo, m.routing.Remove)
+	}
+
+	if m.apps != nil {
+		// Apply can fail halfway, so its undo goes in first.
+		undo = append(undo, m.apps.Remove)
+		skipped, err := m.apps.Apply()
+		if err != nil {
+			return fmt.Errorf("failed to set up per-application tunneling: %w", err)
+		}
+		for _, unit := range skipped {
+			log.Printf("Warning: unit %s not tunneled", unit)
+		}
+	}
+
+	if m.ipv6Guard != nil && m.ipv6 != "disable" && !carriesIPv6(config) {
+		undo = append(undo, m.ipv6Guard.Disable)
+		method, err := m.ipv6Guard.Enable()
+		if err != nil {
+			return fmt.Errorf("failed to block IPv6 outside the tunnel: %w", err)
+		}
+		log.Printf("Tunnel has no IPv6; blocking native IPv6 (%s)", method)
+	}
 
+	if err := m.waitForHandshake(); err != nil {
+		return err
+	}
 	m.isConnected = true
 	log.Println("Successfully connected to Cloudflare WARP")
 	return nil
 }
 
+// waitForHandshake polls the peers' latest handshakes until one completes or
+// the timeout passes.
+func (m *Manager) waitForHandshake() error {
+	if m.timeout <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	for {
+		if handshake, err := m.latestHandshake(); err == nil && !handshake.IsZero() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no handshake with the WARP endpoint within %s", m.timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// latestHandshake returns the most recent handshake of any peer, or the zero
+// time when none has completed.
+func (m *Manager) latestHandshake() (time.Time, error) {
+	output, err := m.wg("show", m.interfaceName, "latest-handshakes").Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read handshakes of %s: %w", m.interfaceName, err)
+	}
+
+	var latest time.Time
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if sec, err := strconv.ParseInt(fields[1], 10, 64); err == nil && sec > 0 {
+			if t := time.Unix(sec, 0); t.After(latest) {
+				latest = t
+			}
+		}
+	}
+	return latest, nil
+}
+
 func (m *Manager) Disconnect() error {
-	if !m.isConnected {
+	// A tunnel brought up by another darp process is still torn down.
+	if !m.isConnected && !m.TunnelUp() {
 		log.Println("Not connected to WARP")
 		return nil
 	}
 
 	log.Println("Disconnecting from Cloudflare WARP...")
 
+	if m.namespace != nil {
+		if err := m.namespace.Delete(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if err := m.cleanupConfig(); err != nil {
+			log.Printf("Warning: failed to cleanup config: %v", err)
+		}
+		m.isConnected = false
+		log.Println("Successfully disconnected from Cloudflare WARP")
+		return nil
+	}
+
+	if m.ipv6Guard != nil {
+		if err := m.ipv6Guard.Disable(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if m.apps != nil {
+		if err := m.apps.Remove(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if m.routing != nil {
+		if err := m.routing.Remove(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
 	if err := m.stopWireGuardInterface(); err != nil {
 		log.Printf("Warning: failed to stop WireGuard interface: %v", err)
 	}
@@ -68,56 +303,318 @@ func (m *Manager) Disconnect() error {
 	return nil
 }
 
+// applyWireGuard puts the listen port, fwmark and keepalive into the WARP
+// configuration.
+func (m *Manager) applyWireGuard(config *Config) {
+	config.Interface.ListenPort = m.listenPort
+	config.Interface.FwMark = m.fwmark
+	if m.routing != nil {
+		config.Interface.FwMark = m.routing.FwMark
+	}
+
+	keepalive := m.keepaliveInterval()
+	for i := range config.Peers {
+		config.Peers[i].PersistentKeepalive = keepalive
+	}
+}
+
+// keepaliveInterval returns the configured interval, or the NAT default when
+// it is automatic and the network translates addresses.
+func (m *Manager) keepaliveInterval() int {
+	if m.keepalive > 0 || !m.autoKeepalive {
+		return m.keepalive
+	}
+
+	// Keepalives are cheap, so an unknown network is treated as NAT.
+	nat, err := network.NewManager(m.interfaceName, nil).DetectNAT(m.traceURL)
+	switch {
+	case err != nil:
+		log.Printf("Warning: NAT detection failed, assuming NAT: %v", err)
+	case !nat.BehindNAT:
+		return 0
+	default:
+		log.Printf("Behind NAT (local address %s); enabling PersistentKeepalive = %d", nat.Local, DefaultNATKeepalive)
+	}
+	return DefaultNATKeepalive
+}
+
+// applyIPv6 adjusts the WARP configuration to the IPv6 mode.
+func (m *Manager) applyIPv6(config *Config) {
+	if m.routing != nil {
+		m.routing.BlockIPv6 = m.ipv6 == "block"
+	}
+
+	if m.ipv6 == "enable" {
+		for i := range config.Peers {
+			config.Peers[i].Endpoint = m.Endpoint(config.Peers[i].Endpoint)
+		}
+		return
+	}
+
+	config.Interface.Addresses = onlyIPv4(config.Interface.Addresses)
+	config.Interface.DNS = onlyIPv4(config.Interface.DNS)
+	if m.ipv6 == "disable" {
+		for i := range config.Peers {
+			config.Peers[i].AllowedIPs = onlyIPv4(config.Peers[i].AllowedIPs)
+		}
+	}
+}
+
+// Endpoint returns the IPv6 endpoint instead of endpoint when IPv6 is
+// tunneled and the physical network only has IPv6.
+func (m *Manager) Endpoint(endpoint string) string {
+	if m.ipv6 == "enable" && m.endpointV6 != "" && ipv6OnlyNetwork() {
+		return m.endpointV6
+	}
+	return endpoint
+}
+
+// ipv6OnlyNetwork reports whether the main table has an IPv6 default route
+// but no IPv4 one.
+func ipv6OnlyNetwork() bool {
+	routes, err := network.ListRoutes()
+	if err != nil {
+		return false
+	}
+	families := make(map[string]bool)
+	for _, route := range routes {
+		if route.Table == network.RouteTableMain && route.IsDefault() && route.Type == "unicast" {
+			families[route.Family] = true
+		}
+	}
+	return families["inet6"] && !families["inet"]
+}
+
+// carriesIPv6 reports whether the tunnel gets an IPv6 address.
+func carriesIPv6(config *Config) bool {
+	return len(onlyIPv4(config.Interface.Addresses)) < len(config.Interface.Addresses)
+}
+
+// onlyIPv4 keeps the IPv4 addresses and prefixes.
+func onlyIPv4(values []string) []string {
+	var kept []string
+	for _, value := range values {
+		host := value
+		if ip, _, err := net.ParseCIDR(value); err == nil {
+			host = ip.String()
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
 func (m *Manager) IsConnected() bool {
 	return m.isConnected
 }
 
+// TunnelUp reports whether the tunnel interface exists, whoever created it.
+func (m *Manager) TunnelUp() bool {
+	if m.namespace != nil {
+		return m.namespace.Exists()
+	}
+	_, err := net.InterfaceByName(m.interfaceName)
+	return err == nil
+}
+
+// GetStatus reports the configured settings and, while the tunnel is up,
+// the live state of the interface and its peer.
 func (m *Manager) GetStatus() map[string]interface{} {
+	up := m.TunnelUp()
 	status := map[string]interface{}{
-		"connected": m.isConnected,
+		"connected": up,
 		"interface": m.interfaceName,
+		"ipv6":      m.ipv6,
+		"mtu":       m.client.MTU,
+		"dns":       m.client.DNS,
+		"endpoint":  m.client.Endpoint,
+	}
+	if m.namespace != nil {
+		status["namespace"] = m.namespace.Name
+	}
+
+	if m.ipv6Guard != nil {
+		status["ipv6_guard"] = m.ipv6Guard.Active()
+	}
+
+	if m.routing != nil {
+		status["policy_routing"] = m.routing.Installed()
+		status["route_table"] = m.routing.Table
 	}
 
-	if m.config != nil {
-		status["peers"] = len(m.config.Peers)
-		status["mtu"] = m.config.MTU
-		status["dns"] = m.config.Interface.DNS
+	if !up {
+		return status
+	}
+
+	if addresses, err := m.addresses(); err == nil {
+		status["addresses"] = addresses
+	}
+
+	// wg show dump prints the interface line, then one line per peer:
+	// public key, preshared key, endpoint, allowed IPs, latest handshake,
+	// bytes received, bytes sent and persistent keepalive.
+	output, err := m.wg("show", m.interfaceName, "dump").Output()
+	if err != nil {
+		return status
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if fields := strings.Split(lines[0], "\t"); len(fields) >= 4 {
+		status["public_key"] = fields[1]
+		status["listen_port"], _ = strconv.Atoi(fields[2])
+		status["fwmark"] = fields[3]
+	}
+
+	var received, sent int64
+	var handshake int64
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			continue
+		}
+		status["endpoint"] = fields[2]
+		status["persistent_keepalive"] = fields[7]
+		if sec, _ := strconv.ParseInt(fields[4], 10, 64); sec > handshake {
+			handshake = sec
+		}
+		rx, _ := strconv.ParseInt(fields[5], 10, 64)
+		tx, _ := strconv.ParseInt(fields[6], 10, 64)
+		received += rx
+		sent += tx
+	}
+	status["peers"] = len(lines) - 1
+	status["bytes_received"] = received
+	status["bytes_sent"] = sent
+	if handshake > 0 {
+		status["latest_handshake"] = time.Unix(handshake, 0).Format(time.RFC3339)
 	}
 
 	return status
 }
 
+// addresses lists the addresses assigned to the tunnel interface.
+func (m *Manager) addresses() ([]string, error) {
+	args := []string{"-o", "addr", "show", "dev", m.interfaceName}
+	cmd := exec.Command("ip", args...)
+	if m.namespace != nil {
+		cmd = m.namespace.Command("", append([]string{"ip"}, args...)...)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read addresses of %s: %w", m.interfaceName, err)
+	}
+
+	var addresses []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "inet" || fields[i] == "inet6" {
+				addresses = append(addresses, fields[i+1])
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// Refresh makes a running tunnel follow a change of the physical network.
+// Every peer's endpoint is set again, which re-resolves it and drops the
+// cached source address, the socket is rebound to a new port so stale NAT
+// mappings are abandoned, and a packet is sent through the tunnel so the
+// peer learns the new address or a fresh handshake starts.
+func (m *Manager) Refresh(endpoint string) error {
+	output, err := m.wg("show", m.interfaceName, "peers").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list peers of %s: %w", m.interfaceName, err)
+	}
+
+	// The new network may or may not be behind NAT.
+	keepalive := strconv.Itoa(m.keepaliveInterval())
+	for _, peer := range strings.Fields(string(output)) {
+		if out, err := m.wg("set", m.interfaceName, "peer", peer, "endpoint", endpoint, "persistent-keepalive", keepalive).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to reset endpoint: %s", strings.TrimSpace(string(out)))
+		}
+	}
+
+	// A fixed listen port is restored after rebinding to a random one.
+	ports := []int{0}
+	if m.listenPort > 0 {
+		ports = append(ports, m.listenPort)
+	}
+	for _, port := range ports {
+		if out, err := m.wg("set", m.interfaceName, "listen-port", strconv.Itoa(port)).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to rebind %s: %s", m.interfaceName, strings.TrimSpace(string(out)))
+		}
+	}
+
+	nudge := network.Target{Name: "tunnel refresh", Host: "1.1.1.1", Port: 443, Protocol: "tcp"}
+	if m.namespace != nil {
+		m.namespace.Command("", "ping", "-c", "1", "-W", "2", nudge.Host).Run()
+	} else {
+		if bound, err := network.NewManager(m.interfaceName, nil).WithBinding(network.Binding{Device: m.interfaceName}); err == nil {
+			bound.CheckTarget(nudge)
+		}
+	}
+
+	log.Printf("Refreshed %s for endpoint %s", m.interfaceName, endpoint)
+	return nil
+}
+
+// wg runs wg where the interface lives.
+func (m *Manager) wg(args ...string) *exec.Cmd {
+	if m.namespace != nil {
+		return m.namespace.Command("", append([]string{"wg"}, args...)...)
+	}
+	return exec.Command("wg", args...)
+}
+
+func (m *Manager) configPath() string {
+	return filepath.Join("/etc/wireguard", m.interfaceName+".conf")
+}
+
 func (m *Manager) createWireGuardConfig(config *Config) error {
 	configDir := "/etc/wireguard"
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	configPath := filepath.Join(configDir, "darp.conf")
+	configPath := m.configPath()
 
 	var wgConfig strings.Builder
 	wgConfig.WriteString("[Interface]\n")
 	wgConfig.WriteString(fmt.Sprintf("PrivateKey = %s\n", config.Interface.PrivateKey))
-	
+
 	for _, addr := range config.Interface.Addresses {
 		wgConfig.WriteString(fmt.Sprintf("Address = %s\n", addr))
 	}
-	
+
 	for _, dns := range config.Interface.DNS {
 		wgConfig.WriteString(fmt.Sprintf("DNS = %s\n", dns))
 	}
-	
+
 	wgConfig.WriteString(fmt.Sprintf("MTU = %d\n", config.MTU))
+	if config.Interface.ListenPort > 0 {
+		wgConfig.WriteString(fmt.Sprintf("ListenPort = %d\n", config.Interface.ListenPort))
+	}
+	if config.Interface.FwMark > 0 {
+		wgConfig.WriteString(fmt.Sprintf("FwMark = %d\n", config.Interface.FwMark))
+	}
+	if m.routing != nil {
+		// darp installs the routes and rules itself.
+		wgConfig.WriteString("Table = off\n")
+	}
 	wgConfig.WriteString("\n")
 
 	for _, peer := range config.Peers {
 		wgConfig.WriteString("[Peer]\n")
 		wgConfig.WriteString(fmt.Sprintf("PublicKey = %s\n", peer.PublicKey))
 		wgConfig.WriteString(fmt.Sprintf("Endpoint = %s\n", peer.Endpoint))
-		
+
 		for _, allowedIP := range peer.AllowedIPs {
 			wgConfig.WriteString(fmt.Sprintf("AllowedIPs = %s\n", allowedIP))
 		}
+		if peer.PersistentKeepalive > 0 {
+			wgConfig.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", peer.PersistentKeepalive))
+		}
 		wgConfig.WriteString("\n")
 	}
 
@@ -134,7 +631,7 @@ func (m *Manager) startWireGuardInterface() error {
 		return fmt.Errorf("WireGuard is not installed. Please install it first: sudo pacman -S wireguard-tools")
 	}
 
-	cmd := exec.Command("sudo", "wg-quick", "up", "darp")
+	cmd := exec.Command("sudo", "wg-quick", "up", m.interfaceName)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -146,8 +643,38 @@ func (m *Manager) startWireGuardInterface() error {
 	return nil
 }
 
+func (m *Manager) startNamespacedInterface(config *Config) error {
+	if err := exec.Command("ip", "link", "add", m.interfaceName, "type", "wireguard").Run(); err != nil {
+		return fmt.Errorf("failed to create %s: %w", m.interfaceName, err)
+	}
+
+	// wg setconf only understands the keys and peers; strip the wg-quick
+	// settings, which Namespace.Setup applies inside the namespace.
+	stripped, err := exec.Command("wg-quick", "strip", m.interfaceName).Output()
+	if err == nil {
+		setconf := exec.Command("wg", "setconf", m.interfaceName, "/dev/stdin")
+		setconf.Stdin = strings.NewReader(string(stripped))
+		var output []byte
+		output, err = setconf.CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(output)))
+		}
+	}
+	if err != nil {
+		exec.Command("ip", "link", "del", m.interfaceName).Run()
+		return fmt.Errorf("failed to configure %s: %w", m.interfaceName, err)
+	}
+
+	if err := m.namespace.Setup(config.Interface.Addresses, config.MTU); err != nil {
+		exec.Command("ip", "link", "del", m.interfaceName).Run()
+		m.namespace.Delete()
+		return err
+	}
+	return nil
+}
+
 func (m *Manager) stopWireGuardInterface() error {
-	cmd := exec.Command("sudo", "wg-quick", "down", "darp")
+	cmd := exec.Command("sudo", "wg-quick", "down", m.interfaceName)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -160,8 +687,7 @@ func (m *Manager) stopWireGuardInterface() error {
 }
 
 func (m *Manager) cleanupConfig() error {
-	configPath := "/etc/wireguard/darp.conf"
-	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+	if err := os.Remove(m.configPath()); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove config file: %w", err)
 	}
 	return nil
@@ -180,15 +706,14 @@ func CheckWireGuardInstallation() error {
 }
 
 func (m *Manager) GetInterfaceInfo() (map[string]string, error) {
-	cmd := exec.Command("wg", "show", "darp")
-	output, err := cmd.Output()
+	output, err := m.wg("show", m.interfaceName).Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface info: %w", err)
 	}
 
 	info := make(map[string]string)
 	lines := strings.Split(string(output), "\n")
-	
+
 	for _, line := range lines {
 		if strings.Contains(line, "interface:") {
 			info["interface"] = strings.TrimSpace(strings.Split(line, ":")[1])